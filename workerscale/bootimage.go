@@ -0,0 +1,204 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerscale
+
+import (
+	"encoding/json"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// BootImageResolver extracts the boot image from a raw machine providerSpec or infrastructure template
+type BootImageResolver interface {
+	ResolveBootImage(raw []byte) (string, error)
+}
+
+// NewBootImageResolver returns the machine api providerSpec resolver for a platform
+func NewBootImageResolver(platform string) BootImageResolver {
+	switch platform {
+	case AzurePlatform:
+		return &azureProviderSpecResolver{}
+	case GCPPlatform:
+		return &gcpProviderSpecResolver{}
+	case VSpherePlatform:
+		return &vsphereProviderSpecResolver{}
+	case BareMetalPlatform:
+		return &bareMetalProviderSpecResolver{}
+	default:
+		return &awsProviderSpecResolver{}
+	}
+}
+
+// NewCAPIBootImageResolver returns the resolver for a cluster api infrastructure template kind
+func NewCAPIBootImageResolver(templateKind string) BootImageResolver {
+	switch templateKind {
+	case "AWSMachineTemplate":
+		return &awsMachineTemplateResolver{}
+	case "AzureMachineTemplate":
+		return &azureMachineTemplateResolver{}
+	case "GCPMachineTemplate":
+		return &gcpMachineTemplateResolver{}
+	case "VSphereMachineTemplate":
+		return &vsphereMachineTemplateResolver{}
+	case "DockerMachineTemplate":
+		return &dockerMachineTemplateResolver{}
+	default:
+		return &unknownMachineTemplateResolver{templateKind: templateKind}
+	}
+}
+
+type awsProviderSpecResolver struct{}
+
+// ResolveBootImage returns the AMI ID
+func (r *awsProviderSpecResolver) ResolveBootImage(raw []byte) (string, error) {
+	var awsSpec AWSProviderSpec
+	if err := json.Unmarshal(raw, &awsSpec); err != nil {
+		return "", err
+	}
+	return awsSpec.AMI.ID, nil
+}
+
+type azureProviderSpecResolver struct{}
+
+// ResolveBootImage returns the image resource ID or the marketplace image URN
+func (r *azureProviderSpecResolver) ResolveBootImage(raw []byte) (string, error) {
+	var azureSpec AzureProviderSpec
+	if err := json.Unmarshal(raw, &azureSpec); err != nil {
+		return "", err
+	}
+	// Images referenced by ID take precedence over marketplace images
+	if azureSpec.Image.ResourceID != "" {
+		return azureSpec.Image.ResourceID, nil
+	}
+	return fmt.Sprintf("%s:%s:%s:%s", azureSpec.Image.Publisher, azureSpec.Image.Offer, azureSpec.Image.SKU, azureSpec.Image.Version), nil
+}
+
+type gcpProviderSpecResolver struct{}
+
+// ResolveBootImage returns the image of the boot disk
+func (r *gcpProviderSpecResolver) ResolveBootImage(raw []byte) (string, error) {
+	var gcpSpec GCPProviderSpec
+	if err := json.Unmarshal(raw, &gcpSpec); err != nil {
+		return "", err
+	}
+	for _, disk := range gcpSpec.Disks {
+		if disk.Boot {
+			return disk.Image, nil
+		}
+	}
+	return "", nil
+}
+
+type vsphereProviderSpecResolver struct{}
+
+// ResolveBootImage returns the VM template the machine is cloned from
+func (r *vsphereProviderSpecResolver) ResolveBootImage(raw []byte) (string, error) {
+	var vsphereSpec VSphereProviderSpec
+	if err := json.Unmarshal(raw, &vsphereSpec); err != nil {
+		return "", err
+	}
+	return vsphereSpec.Template, nil
+}
+
+type bareMetalProviderSpecResolver struct{}
+
+// ResolveBootImage returns the image url or the custom deploy method
+func (r *bareMetalProviderSpecResolver) ResolveBootImage(raw []byte) (string, error) {
+	var bareMetalSpec BareMetalProviderSpec
+	if err := json.Unmarshal(raw, &bareMetalSpec); err != nil {
+		return "", err
+	}
+	// Hosts deployed with a custom deploy method like install_coreos have no image url
+	if bareMetalSpec.Image.URL == "" {
+		return bareMetalSpec.CustomDeploy.Method, nil
+	}
+	return bareMetalSpec.Image.URL, nil
+}
+
+type awsMachineTemplateResolver struct{}
+
+// ResolveBootImage returns the AMI ID of the template
+func (r *awsMachineTemplateResolver) ResolveBootImage(raw []byte) (string, error) {
+	var template AWSMachineTemplate
+	if err := json.Unmarshal(raw, &template); err != nil {
+		return "", err
+	}
+	return template.Spec.Template.Spec.AMI.ID, nil
+}
+
+type azureMachineTemplateResolver struct{}
+
+// ResolveBootImage returns the image ID, the compute gallery image or the marketplace image URN of the template
+func (r *azureMachineTemplateResolver) ResolveBootImage(raw []byte) (string, error) {
+	var template AzureMachineTemplate
+	if err := json.Unmarshal(raw, &template); err != nil {
+		return "", err
+	}
+	image := template.Spec.Template.Spec.Image
+	if image.ID != "" {
+		return image.ID, nil
+	}
+	if image.ComputeGallery.Name != "" {
+		return fmt.Sprintf("%s/%s/%s", image.ComputeGallery.Gallery, image.ComputeGallery.Name, image.ComputeGallery.Version), nil
+	}
+	return fmt.Sprintf("%s:%s:%s:%s", image.Marketplace.Publisher, image.Marketplace.Offer, image.Marketplace.SKU, image.Marketplace.Version), nil
+}
+
+type gcpMachineTemplateResolver struct{}
+
+// ResolveBootImage returns the image or the image family of the template
+func (r *gcpMachineTemplateResolver) ResolveBootImage(raw []byte) (string, error) {
+	var template GCPMachineTemplate
+	if err := json.Unmarshal(raw, &template); err != nil {
+		return "", err
+	}
+	if template.Spec.Template.Spec.Image != "" {
+		return template.Spec.Template.Spec.Image, nil
+	}
+	return template.Spec.Template.Spec.ImageFamily, nil
+}
+
+type vsphereMachineTemplateResolver struct{}
+
+// ResolveBootImage returns the VM template of the template
+func (r *vsphereMachineTemplateResolver) ResolveBootImage(raw []byte) (string, error) {
+	var template VSphereMachineTemplate
+	if err := json.Unmarshal(raw, &template); err != nil {
+		return "", err
+	}
+	return template.Spec.Template.Spec.Template, nil
+}
+
+type dockerMachineTemplateResolver struct{}
+
+// ResolveBootImage returns the custom node image of the template, empty when using the default kind image
+func (r *dockerMachineTemplateResolver) ResolveBootImage(raw []byte) (string, error) {
+	var template DockerMachineTemplate
+	if err := json.Unmarshal(raw, &template); err != nil {
+		return "", err
+	}
+	return template.Spec.Template.Spec.CustomImage, nil
+}
+
+type unknownMachineTemplateResolver struct {
+	templateKind string
+}
+
+// ResolveBootImage reports no boot image, the provider of the template is not known
+func (r *unknownMachineTemplateResolver) ResolveBootImage(raw []byte) (string, error) {
+	log.Warnf("Unable to resolve the boot image of unsupported infrastructure template kind %s", r.templateKind)
+	return "", nil
+}
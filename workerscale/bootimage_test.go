@@ -0,0 +1,49 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerscale
+
+import "testing"
+
+func TestCAPIBootImageResolver(t *testing.T) {
+	tests := []struct {
+		name         string
+		templateKind string
+		template     string
+		expected     string
+	}{
+		{
+			name:         "docker custom image",
+			templateKind: "DockerMachineTemplate",
+			template:     `{"spec":{"template":{"spec":{"customImage":"kindest/node:v1.31.0"}}}}`,
+			expected:     "kindest/node:v1.31.0",
+		},
+		{
+			name:         "unsupported provider",
+			templateKind: "OpenStackMachineTemplate",
+			template:     `{"spec":{"template":{"spec":{"image":{"filter":{"name":"rhcos"}}}}}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bootImageID, err := NewCAPIBootImageResolver(tt.templateKind).ResolveBootImage([]byte(tt.template))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if bootImageID != tt.expected {
+				t.Errorf("got boot image %q, expected %q", bootImageID, tt.expected)
+			}
+		})
+	}
+}
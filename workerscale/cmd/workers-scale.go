@@ -15,9 +15,12 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/cloud-bulldozer/go-commons/indexers"
@@ -35,16 +38,29 @@ import (
 	wscale "github.com/vishnuchalla/workers-scale/workerscale"
 	core "github.com/vishnuchalla/workers-scale/workerscale/core"
 	platforms "github.com/vishnuchalla/workers-scale/workerscale/platforms"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
 )
 
 // rootCmd represents the base command when called without any subcommands
 var err error
-var enableAutoscaler, isHCP, gc bool
-var uuid, mcKubeConfig string
+var enableAutoscaler, isHCP, gc, stateConfigMap bool
+var uuid, mcKubeConfig, stateDir, probeImage string
+var capiClusterName, capiNamespace string
+var placementStrategy, placementTarget, placementFile string
+var placementWeights, placementCounts map[string]int
+var machineSetSelector string
+var machineSetInclude, machineSetExclude []string
+var ephemeralTemplate, ephemeralInstanceType, ephemeralBootImage, ephemeralZone string
+var compareBootImage, compareInstanceType string
+var ephemeralLabels map[string]string
+var ephemeralTaints []string
 var metricsProfiles []string
-var prometheusStep time.Duration
+var prometheusStep, timeout, scaleUpTimeout, scaleDownTimeout time.Duration
 var scaleEventEpoch, start, end int64
-var rc, additionalWorkerNodes int
+var rc, additionalWorkerNodes, iterations int
 var prometheusURL, prometheusToken string
 var userMetadata, metricsDirectory, tarballName string
 var indexer config.MetricsEndpoint
@@ -52,9 +68,13 @@ var clusterMetadataMap map[string]interface{}
 var ocpMetaAgent ocpmetadata.Metadata
 var metricsEndpoint string
 var esServer, esIndex string
+var resumeState *wscale.RunState
 
 const autoScaled = "autoScaled"
 const imageID = "imageId"
+const iterationsKey = "iterations"
+const iterationSummary = "iterationSummary"
+const csrIssuesKey = "csrIssues"
 
 var rootCmd = &cobra.Command{
 	Use:   "workers-scale",
@@ -71,15 +91,74 @@ var rootCmd = &cobra.Command{
 			start = time.Now().Unix()
 		}
 		jobEnd := end
+		if iterations < 1 {
+			log.Fatal("Iterations must be greater than 0")
+		}
+		if iterations > 1 && (!gc || scaleEventEpoch != 0) {
+			log.Fatal("Multiple iterations require garbage collection enabled and no scale event epoch")
+		}
+		if !slices.Contains([]string{wscale.EvenPlacement, wscale.ZoneRoundRobinPlacement, wscale.WeightedPlacement, wscale.SinglePlacement, wscale.ProportionalPlacement, wscale.ExplicitPlacement}, placementStrategy) {
+			log.Fatalf("Unknown placement strategy: %s", placementStrategy)
+		}
+		if placementStrategy == wscale.WeightedPlacement && len(placementWeights) == 0 {
+			log.Fatal("Weighted placement requires the placement weights")
+		}
+		machineSetFilter, err := wscale.NewMachineSetFilter(machineSetSelector, machineSetInclude, machineSetExclude)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if placementStrategy == wscale.ExplicitPlacement {
+			if placementCounts, err = loadPlacementCounts(placementFile); err != nil {
+				log.Fatalf("Error loading placement file: %v", err)
+			}
+			// The explicit counts decide how many workers are added
+			additionalWorkerNodes = 0
+			for _, count := range placementCounts {
+				additionalWorkerNodes += count
+			}
+			log.Infof("Adding %d workers as given by the placement file", additionalWorkerNodes)
+		}
+		if compareBootImage != "" || compareInstanceType != "" {
+			if ephemeralTemplate == "" || enableAutoscaler {
+				log.Fatal("Comparison requires an ephemeral machineset and the autoscaler disabled")
+			}
+			// Both variants must receive the same number of workers
+			if placementStrategy != wscale.EvenPlacement {
+				log.Fatalf("Comparison only supports the %s placement", wscale.EvenPlacement)
+			}
+		}
+		taints, err := parseTaints(ephemeralTaints)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		if timeout > 0 {
+			var cancelTimeout context.CancelFunc
+			ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+			defer cancelTimeout()
+		}
+		// Revert the changes made to the cluster when interrupted or on a fatal error, once the flags are validated
+		wscale.SetupRollbackHandlers(cancel)
 		uuid, _ = cmd.Flags().GetString("uuid")
 		kubeClientProvider := config.NewKubeClientProvider("", "")
-		_, restConfig := kubeClientProvider.DefaultClientSet()
+		clientSet, restConfig := kubeClientProvider.DefaultClientSet()
+		if resumeState == nil {
+			var stateClientSet kubernetes.Interface
+			if stateConfigMap {
+				stateClientSet = clientSet
+			}
+			wscale.InitRunState(uuid, stateDir, stateClientSet)
+		}
 		ocpMetaAgent, err = ocpmetadata.NewMetadata(restConfig)
 		workloads.ConfigSpec.GlobalConfig.UUID = uuid
 		// When metricsEndpoint is specified, don't fetch any prometheus token
 		if metricsEndpoint == "" {
 			prometheusURL, prometheusToken, err = ocpMetaAgent.GetPrometheus()
-			if err != nil {
+			// Vanilla cluster api clusters, like kind with CAPD, may not have an openshift monitoring stack
+			if err != nil && capiClusterName != "" {
+				log.Warn("Skipping prometheus metrics, unable to obtain prometheus information from cluster: ", err.Error())
+			} else if err != nil {
 				log.Fatal("Error obtaining prometheus information from cluster: ", err.Error())
 			}
 		}
@@ -109,10 +188,17 @@ var rootCmd = &cobra.Command{
 
 		clusterMetadata, err := ocpMetaAgent.GetClusterMetadata()
 		if scaleEventEpoch == 0 {
-			clusterMetadata.WorkerNodesCount += additionalWorkerNodes
-			clusterMetadata.TotalNodes += additionalWorkerNodes
+			scaledWorkerNodes := additionalWorkerNodes
+			// Both compared variants are scaled by the same amount
+			if compareBootImage != "" || compareInstanceType != "" {
+				scaledWorkerNodes *= 2
+			}
+			clusterMetadata.WorkerNodesCount += scaledWorkerNodes
+			clusterMetadata.TotalNodes += scaledWorkerNodes
 		}
-		if err != nil {
+		if err != nil && capiClusterName != "" {
+			log.Warn("Unable to obtain clusterMetadata: ", err.Error())
+		} else if err != nil {
 			log.Fatal("Error obtaining clusterMetadata: ", err.Error())
 		}
 		metadata := make(map[string]interface{})
@@ -142,7 +228,7 @@ var rootCmd = &cobra.Command{
 			indexerValue = value
 			break
 		}
-		scenario := fetchScenario(enableAutoscaler, clusterMetadata)
+		scenario := fetchScenario(enableAutoscaler, capiClusterName, mcKubeConfig, clusterMetadata)
 		if _, ok := scenario.(*platforms.RosaScenario); ok {
 			if clusterMetadata.MasterNodesCount == 0 && clusterMetadata.InfraNodesCount == 0 {
 				isHCP = true
@@ -150,21 +236,110 @@ var rootCmd = &cobra.Command{
 		} else {
 			isHCP = false
 		}
+		switch scenario.(type) {
+		case *platforms.RosaScenario, *platforms.HyperShiftScenario:
+			// Their machinepools and nodepools are scaled as a whole
+			if machineSetFilter != nil {
+				log.Fatal("Machineset selection is only supported with machine api machinesets and cluster api machinedeployments")
+			}
+		}
+		switch scenario.(type) {
+		case *platforms.RosaScenario, *platforms.HyperShiftScenario, *core.CAPIScenario:
+			if ephemeralTemplate != "" {
+				log.Fatal("Ephemeral machinesets and comparisons are only supported with machine api machinesets")
+			}
+		}
 		if isHCP {
 			metricsScraper.SummaryMetadata[wscale.ClusterType] = wscale.RosaHCP
 		}
-		imageId := scenario.OrchestrateWorkload(wscale.ScaleConfig{
-			UUID:                  uuid,
-			AdditionalWorkerNodes: additionalWorkerNodes,
-			Metadata:              metricsScraper.MetricsMetadata,
-			Indexer:               indexerValue,
-			GC:                    gc,
-			ScaleEventEpoch:       scaleEventEpoch,
-			AutoScalerEnabled:     enableAutoscaler,
-			MCKubeConfig:          mcKubeConfig,
-			IsHCP:                 isHCP,
-		})
-		metricsScraper.SummaryMetadata[imageID] = imageId
+		if _, ok := scenario.(*core.CAPIScenario); ok {
+			metricsScraper.SummaryMetadata[wscale.ClusterType] = wscale.CAPI
+		}
+		if _, ok := scenario.(*platforms.HyperShiftScenario); ok {
+			metricsScraper.SummaryMetadata[wscale.ClusterType] = wscale.HyperShift
+		}
+		if azureScenario, ok := scenario.(*platforms.AzureScenario); ok {
+			azureScenario.ARO = platforms.IsARO(ctx, dynamic.NewForConfigOrDie(restConfig))
+			if azureScenario.ARO {
+				metricsScraper.SummaryMetadata[wscale.ClusterType] = wscale.ARO
+			}
+		}
+		// Without an explicit placement, scenarios pick their default strategy, the even one otherwise
+		scenarioPlacementStrategy := placementStrategy
+		if !cmd.Flags().Changed("placement") {
+			scenarioPlacementStrategy = ""
+		}
+		var imageIds []string
+		var executionErrors []string
+		for iteration := 1; iteration <= iterations; iteration++ {
+			if iterations > 1 {
+				log.Infof("Starting iteration %d/%d", iteration, iterations)
+			}
+			imageId, err := scenario.OrchestrateWorkload(ctx, wscale.ScaleConfig{
+				UUID:                  uuid,
+				AdditionalWorkerNodes: additionalWorkerNodes,
+				Metadata:              metricsScraper.MetricsMetadata,
+				Indexer:               indexerValue,
+				GC:                    gc,
+				ScaleEventEpoch:       scaleEventEpoch,
+				AutoScalerEnabled:     enableAutoscaler,
+				MCKubeConfig:          mcKubeConfig,
+				IsHCP:                 isHCP,
+				Platform:              clusterMetadata.Platform,
+				CAPIClusterName:       capiClusterName,
+				CAPINamespace:         capiNamespace,
+				Iteration:             iteration,
+				ScaleUpTimeout:        scaleUpTimeout,
+				ScaleDownTimeout:      scaleDownTimeout,
+				ProbeImage:            probeImage,
+				PlacementStrategy:     scenarioPlacementStrategy,
+				PlacementWeights:      placementWeights,
+				PlacementTarget:       placementTarget,
+				PlacementCounts:       placementCounts,
+				MachineSetFilter:      machineSetFilter,
+				EphemeralMachineSet: wscale.EphemeralMachineSetConfig{
+					Template:            ephemeralTemplate,
+					InstanceType:        ephemeralInstanceType,
+					BootImage:           ephemeralBootImage,
+					Zone:                ephemeralZone,
+					Labels:              ephemeralLabels,
+					Taints:              taints,
+					CompareBootImage:    compareBootImage,
+					CompareInstanceType: compareInstanceType,
+				},
+			})
+			if err != nil {
+				log.Errorf("Error running workers-scale: %v", err)
+				executionErrors = append(executionErrors, err.Error())
+				// Revert whatever the failed scenario left behind before reporting
+				wscale.Rollback()
+				rc = 1
+				break
+			}
+			if !slices.Contains(imageIds, imageId) {
+				imageIds = append(imageIds, imageId)
+			}
+		}
+		metricsScraper.SummaryMetadata[imageID] = strings.Join(imageIds, ",")
+		if resumeState != nil && rc == 0 {
+			if gc {
+				if err := cleanupRun(*resumeState); err != nil {
+					log.Error(err)
+					executionErrors = append(executionErrors, err.Error())
+					rc = 1
+				}
+			} else {
+				wscale.CompleteRunState(false)
+			}
+		}
+		if iterations > 1 {
+			metricsScraper.SummaryMetadata[iterationsKey] = iterations
+			metricsScraper.SummaryMetadata[iterationSummary] = wscale.GetIterationSummary()
+		}
+		// Nodes whose CSRs were not approved never join the cluster
+		if csrIssues := wscale.GetCSRIssues(); len(csrIssues) > 0 {
+			metricsScraper.SummaryMetadata[csrIssuesKey] = csrIssues
+		}
 		if end == 0 {
 			jobEnd = time.Now().Unix()
 			end = jobEnd + wscale.TenMinutes
@@ -196,10 +371,11 @@ var rootCmd = &cobra.Command{
 			JobConfig: config.Job{
 				Name: wscale.JobName,
 			},
-			Metadata:   metricsScraper.SummaryMetadata,
-			MetricName: "jobSummary",
-			Version:    fmt.Sprintf("%v@%v", version.Version, version.GitCommit),
-			Passed:     rc == 0,
+			Metadata:        metricsScraper.SummaryMetadata,
+			MetricName:      "jobSummary",
+			Version:         fmt.Sprintf("%v@%v", version.Version, version.GitCommit),
+			Passed:          rc == 0,
+			ExecutionErrors: strings.Join(executionErrors, "\n"),
 		}
 		burner.IndexJobSummary([]burner.JobSummary{jobSummary}, indexerValue)
 		log.Info("👋 Exiting workers-scale ", uuid)
@@ -207,6 +383,45 @@ var rootCmd = &cobra.Command{
 	},
 }
 
+var cleanupCmd = &cobra.Command{
+	Use:   "cleanup",
+	Short: "Rolls back the changes made to the cluster by a previous run",
+	Long:  `Restores the machinesets, machine pools, nodepools and machinedeployments of a previous run to their original replicas and deletes the autoscalers, jobs and ephemeral machinesets it created, using the state recorded by the run`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		util.ConfigureLogging(cmd)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		runState := loadRunState(cmd)
+		if err := cleanupRun(runState); err != nil {
+			log.Fatal(err)
+		}
+		log.Info("👋 Cleaned up workers-scale ", runState.UUID)
+	},
+}
+
+var resumeCmd = &cobra.Command{
+	Use:   "resume",
+	Short: "Finishes measuring a half-complete run",
+	Long:  `Measures the bootup times of the nodes scaled by a previous run which did not complete, and garbage collects them afterwards, using the state recorded by the run`,
+	PreRun: func(cmd *cobra.Command, args []string) {
+		util.ConfigureLogging(cmd)
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		runState := loadRunState(cmd)
+		if runState.Completed {
+			log.Fatalf("Run %s already completed, use cleanup to restore the cluster", runState.UUID)
+		}
+		if runState.ScaleEventEpoch == 0 {
+			log.Fatalf("Run %s did not scale the cluster, nothing to resume", runState.UUID)
+		}
+		resumeState = &runState
+		scaleEventEpoch = runState.ScaleEventEpoch
+		enableAutoscaler = false
+		iterations = 1
+		rootCmd.Run(cmd, args)
+	},
+}
+
 func init() {
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
@@ -216,36 +431,150 @@ func init() {
 
 	// Cobra also supports local flags, which will only run
 	// when this action is called directly.
-	rootCmd.Flags().StringSliceVar(&metricsProfiles, "metrics-profile", []string{"metrics-nodebootup.yml", "metrics-nodebootup-report.yml"}, "Comma separated list of metrics profiles to use")
-	rootCmd.Flags().StringVar(&metricsEndpoint, "metrics-endpoint", "", "YAML file with a list of metric endpoints, overrides the es-server and es-index flags")
-	rootCmd.Flags().Int64Var(&start, "start", 0, "Epoch start time")
-	rootCmd.Flags().Int64Var(&end, "end", 0, "Epoch end time")
-	rootCmd.Flags().StringVar(&esServer, "es-server", "", "Elastic Search endpoint")
-	rootCmd.Flags().StringVar(&esIndex, "es-index", "", "Elastic Search index")
-	rootCmd.Flags().StringVar(&uuid, "uuid", uid.NewString(), "Benchmark UUID")
-	rootCmd.Flags().BoolVar(&gc, "gc", true, "Garbage collect created resources")
-	rootCmd.Flags().StringVar(&metricsDirectory, "metrics-directory", "collected-metrics", "Directory to dump the metrics files in, when using default local indexing")
-	rootCmd.Flags().StringVar(&mcKubeConfig, "mc-kubeconfig", "", "Path for management cluster kubeconfig")
-	rootCmd.Flags().DurationVar(&prometheusStep, "step", 30*time.Second, "Prometheus step size")
-	rootCmd.Flags().IntVar(&additionalWorkerNodes, "additional-worker-nodes", 3, "Additional workers to scale")
-	rootCmd.Flags().BoolVar(&enableAutoscaler, "enable-autoscaler", false, "Enables autoscaler while scaling the cluster")
-	rootCmd.Flags().Int64Var(&scaleEventEpoch, "scale-event-epoch", 0, "Scale event epoch time")
-	rootCmd.Flags().StringVar(&userMetadata, "user-metadata", "", "User provided metadata file, in YAML format")
-	rootCmd.Flags().StringVar(&tarballName, "tarball-name", "", "Dump collected metrics into a tarball with the given name, requires local indexing")
-	rootCmd.Flags().SortFlags = false
+	rootCmd.PersistentFlags().StringSliceVar(&metricsProfiles, "metrics-profile", []string{"metrics-nodebootup.yml", "metrics-nodebootup-report.yml"}, "Comma separated list of metrics profiles to use")
+	rootCmd.PersistentFlags().StringVar(&metricsEndpoint, "metrics-endpoint", "", "YAML file with a list of metric endpoints, overrides the es-server and es-index flags")
+	rootCmd.PersistentFlags().Int64Var(&start, "start", 0, "Epoch start time")
+	rootCmd.PersistentFlags().Int64Var(&end, "end", 0, "Epoch end time")
+	rootCmd.PersistentFlags().StringVar(&esServer, "es-server", "", "Elastic Search endpoint")
+	rootCmd.PersistentFlags().StringVar(&esIndex, "es-index", "", "Elastic Search index")
+	rootCmd.PersistentFlags().StringVar(&uuid, "uuid", uid.NewString(), "Benchmark UUID")
+	rootCmd.PersistentFlags().BoolVar(&gc, "gc", true, "Garbage collect created resources, measuring the scale down latencies of restored machinesets")
+	rootCmd.PersistentFlags().StringVar(&metricsDirectory, "metrics-directory", "collected-metrics", "Directory to dump the metrics files in, when using default local indexing")
+	rootCmd.PersistentFlags().StringVar(&mcKubeConfig, "mc-kubeconfig", "", "Path for management cluster kubeconfig")
+	rootCmd.PersistentFlags().StringVar(&capiClusterName, "capi-cluster-name", "", "Cluster API cluster name, scales its machinedeployments on the management cluster")
+	rootCmd.PersistentFlags().StringVar(&capiNamespace, "capi-namespace", wscale.DefaultNamespace, "Namespace of the cluster API cluster in the management cluster")
+	rootCmd.PersistentFlags().DurationVar(&prometheusStep, "step", 30*time.Second, "Prometheus step size")
+	rootCmd.PersistentFlags().IntVar(&additionalWorkerNodes, "additional-worker-nodes", 3, "Additional workers to scale")
+	rootCmd.PersistentFlags().StringVar(&machineSetSelector, "machineset-selector", "", "Label selector of the machinesets to scale, measure and wait on")
+	rootCmd.PersistentFlags().StringSliceVar(&machineSetInclude, "machineset-include", []string{}, "Comma separated name globs of the machinesets to scale, measure and wait on")
+	rootCmd.PersistentFlags().StringSliceVar(&machineSetExclude, "machineset-exclude", []string{}, "Comma separated name globs of the machinesets to leave untouched")
+	rootCmd.PersistentFlags().StringVar(&ephemeralTemplate, "ephemeral-machineset", "", "Machineset cloned into a dedicated machineset for the run, which is the only one scaled and is deleted afterwards")
+	rootCmd.PersistentFlags().StringVar(&ephemeralInstanceType, "ephemeral-instance-type", "", "Instance type of the ephemeral machineset, defaults to the one of the cloned machineset")
+	rootCmd.PersistentFlags().StringVar(&ephemeralBootImage, "ephemeral-boot-image", "", "Boot image of the ephemeral machineset, defaults to the one of the cloned machineset")
+	rootCmd.PersistentFlags().StringVar(&ephemeralZone, "ephemeral-zone", "", "Zone of the ephemeral machineset, defaults to the one of the cloned machineset")
+	rootCmd.PersistentFlags().StringToStringVar(&ephemeralLabels, "ephemeral-labels", map[string]string{}, "Comma separated key=value labels of the nodes of the ephemeral machineset")
+	rootCmd.PersistentFlags().StringSliceVar(&ephemeralTaints, "ephemeral-taints", []string{}, "Comma separated key=value:Effect taints of the nodes of the ephemeral machineset")
+	rootCmd.PersistentFlags().StringVar(&compareBootImage, "compare-boot-image", "", "Boot image of a second ephemeral machineset scaled alongside the first one to compare their latencies")
+	rootCmd.PersistentFlags().StringVar(&compareInstanceType, "compare-instance-type", "", "Instance type of a second ephemeral machineset scaled alongside the first one to compare their latencies")
+	rootCmd.PersistentFlags().StringVar(&placementStrategy, "placement", wscale.EvenPlacement, "Strategy to distribute the additional workers across machinesets: even, round-robin (across zones), weighted, single, proportional (to current size) or explicit, round-robin by default on GCP")
+	rootCmd.PersistentFlags().StringToIntVar(&placementWeights, "placement-weights", map[string]int{}, "Comma separated machineset=weight ratios, used by the weighted placement")
+	rootCmd.PersistentFlags().StringVar(&placementTarget, "placement-target", "", "Machineset receiving every additional worker with the single placement, defaults to the smallest one")
+	rootCmd.PersistentFlags().StringVar(&placementFile, "placement-file", "", "YAML file mapping machinesets to the number of workers to add, used by the explicit placement")
+	rootCmd.PersistentFlags().IntVar(&iterations, "iterations", 1, "Number of scale up, measure and garbage collect cycles to run")
+	rootCmd.PersistentFlags().BoolVar(&enableAutoscaler, "enable-autoscaler", false, "Enables autoscaler while scaling the cluster")
+	rootCmd.PersistentFlags().Int64Var(&scaleEventEpoch, "scale-event-epoch", 0, "Scale event epoch time")
+	rootCmd.PersistentFlags().StringVar(&userMetadata, "user-metadata", "", "User provided metadata file, in YAML format")
+	rootCmd.PersistentFlags().StringVar(&tarballName, "tarball-name", "", "Dump collected metrics into a tarball with the given name, requires local indexing")
+	rootCmd.PersistentFlags().StringVar(&probeImage, "probe-image", "", "Image of the pod scheduled on every scaled node once ready, to measure when workloads can run on it. Disabled when empty")
+	rootCmd.PersistentFlags().DurationVar(&timeout, "timeout", 0, "Timeout for the whole run, disabled when 0")
+	rootCmd.PersistentFlags().DurationVar(&scaleUpTimeout, "scale-up-timeout", 4*time.Hour, "Timeout for the nodes to be ready after scaling up")
+	rootCmd.PersistentFlags().DurationVar(&scaleDownTimeout, "scale-down-timeout", 4*time.Hour, "Timeout for the nodes to be removed when garbage collecting")
+	rootCmd.PersistentFlags().StringVar(&stateDir, "state-dir", ".", "Directory to record the run state in, used by the cleanup and resume commands")
+	rootCmd.PersistentFlags().BoolVar(&stateConfigMap, "state-configmap", false, "Record the run state in a ConfigMap in the cluster as well")
+	rootCmd.PersistentFlags().SortFlags = false
+	rootCmd.AddCommand(cleanupCmd, resumeCmd)
 	util.SetupCmd(rootCmd)
 }
 
+// loadRunState loads the state of the run given by the uuid flag
+func loadRunState(cmd *cobra.Command) wscale.RunState {
+	if !cmd.Flags().Changed("uuid") {
+		log.Fatal("Please provide the uuid of the run")
+	}
+	uuid, _ = cmd.Flags().GetString("uuid")
+	var stateClientSet kubernetes.Interface
+	if stateConfigMap {
+		kubeClientProvider := config.NewKubeClientProvider("", "")
+		stateClientSet, _ = kubeClientProvider.DefaultClientSet()
+	}
+	runState, err := wscale.LoadRunState(uuid, stateDir, stateClientSet)
+	if err != nil {
+		log.Fatalf("Error loading state of run %s: %v", uuid, err)
+	}
+	return runState
+}
+
+// loadPlacementCounts loads the number of workers to add to each machineset from a YAML file
+func loadPlacementCounts(placementFile string) (map[string]int, error) {
+	if placementFile == "" {
+		return nil, fmt.Errorf("explicit placement requires a placement file")
+	}
+	data, err := os.ReadFile(placementFile)
+	if err != nil {
+		return nil, err
+	}
+	var counts map[string]int
+	if err := yaml.UnmarshalStrict(data, &counts); err != nil {
+		return nil, fmt.Errorf("error unmarshaling %s: %v", placementFile, err)
+	}
+	if len(counts) == 0 {
+		return nil, fmt.Errorf("no machinesets in %s", placementFile)
+	}
+	return counts, nil
+}
+
+// parseTaints parses taints given as key=value:Effect or key:Effect
+func parseTaints(taintSpecs []string) ([]corev1.Taint, error) {
+	var taints []corev1.Taint
+	for _, taintSpec := range taintSpecs {
+		keyValue, effect, found := strings.Cut(taintSpec, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid taint %s, missing effect", taintSpec)
+		}
+		key, value, _ := strings.Cut(keyValue, "=")
+		taint := corev1.Taint{Key: key, Value: value, Effect: corev1.TaintEffect(effect)}
+		if key == "" || !slices.Contains([]corev1.TaintEffect{corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute}, taint.Effect) {
+			return nil, fmt.Errorf("invalid taint %s", taintSpec)
+		}
+		taints = append(taints, taint)
+	}
+	return taints, nil
+}
+
+// cleanupRun rolls back the changes recorded in the state of a run
+func cleanupRun(runState wscale.RunState) error {
+	kubeClientProvider := config.NewKubeClientProvider("", "")
+	clientSet, restConfig := kubeClientProvider.DefaultClientSet()
+	if len(runState.MachinePools) > 0 {
+		log.Info("Restoring machine pools to previous state")
+		if err := platforms.RestoreMachinePools(context.Background(), runState); err != nil {
+			return fmt.Errorf("error restoring machine pools of run %s: %v", runState.UUID, err)
+		}
+	}
+	if err := core.Cleanup(context.Background(), clientSet, restConfig, runState); err != nil {
+		return fmt.Errorf("error cleaning up run %s: %v", runState.UUID, err)
+	}
+	wscale.RemoveRunState()
+	return nil
+}
+
 // FetchScenario helps us to fetch relevant class
-func fetchScenario(enableAutoscaler bool, clusterMetadata ocpmetadata.ClusterMetadata) wscale.Scenario {
-	if clusterMetadata.ClusterType == "rosa" {
+func fetchScenario(enableAutoscaler bool, capiClusterName string, mcKubeConfig string, clusterMetadata ocpmetadata.ClusterMetadata) wscale.Scenario {
+	// Managed and cluster api topologies scale their own pools, the machine api platforms then
+	// run the autoscaler scenario when enabled, or the base scenario otherwise
+	switch {
+	case capiClusterName != "":
+		return &core.CAPIScenario{}
+	case clusterMetadata.ClusterType == "rosa":
 		return &platforms.RosaScenario{}
-	} else {
-		if enableAutoscaler {
-			return &core.AutoScalerScenario{}
-		}
-		return &core.BaseScenario{}
+	case mcKubeConfig != "":
+		return &platforms.HyperShiftScenario{}
+	}
+	// Platform scenarios only add their provider specifics and honor enableAutoscaler through ScaleConfig.AutoScalerEnabled
+	switch clusterMetadata.Platform {
+	case wscale.AzurePlatform:
+		return &platforms.AzureScenario{}
+	case wscale.GCPPlatform:
+		return &platforms.GCPScenario{}
+	case wscale.VSpherePlatform:
+		return &platforms.VSphereScenario{}
+	case wscale.BareMetalPlatform:
+		return &platforms.BareMetalScenario{}
+	}
+	if enableAutoscaler {
+		return &core.AutoScalerScenario{}
 	}
+	return &core.BaseScenario{}
 }
 
 func main() {
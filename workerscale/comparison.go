@@ -0,0 +1,185 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerscale
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/cloud-bulldozer/go-commons/indexers"
+	"github.com/kube-burner/kube-burner/pkg/measurements"
+	mmetrics "github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	mtypes "github.com/kube-burner/kube-burner/pkg/measurements/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// FinalizeComparisonMetrics indexes the phase latencies of the baseline and candidate machinesets side by side
+func FinalizeComparisonMetrics(uuid string, baselineMachineSet string, candidateMachineSet string, nodeReadyLatencies []interface{}, metadata map[string]interface{}, indexerValue indexers.Indexer, iteration int) {
+	var baselineLatencies, candidateLatencies []interface{}
+	for _, nodeReadyLatency := range nodeReadyLatencies {
+		switch nodeReadyLatency.(NodeReadyMetric).MachineSet {
+		case baselineMachineSet:
+			baselineLatencies = append(baselineLatencies, nodeReadyLatency)
+		case candidateMachineSet:
+			candidateLatencies = append(candidateLatencies, nodeReadyLatency)
+		}
+	}
+	if len(baselineLatencies) == 0 || len(candidateLatencies) == 0 {
+		log.Warnf("Skipping comparison, %d nodes measured for %s and %d for %s", len(baselineLatencies), baselineMachineSet, len(candidateLatencies), candidateMachineSet)
+		return
+	}
+	baselineNode := baselineLatencies[0].(NodeReadyMetric)
+	candidateNode := candidateLatencies[0].(NodeReadyMetric)
+	baselinePhases := getQuantileMap(baselineLatencies)
+	candidatePhases := getQuantileMap(candidateLatencies)
+	var phases []string
+	for phase := range baselinePhases {
+		if _, exists := candidatePhases[phase]; exists {
+			phases = append(phases, phase)
+		}
+	}
+	sort.Strings(phases)
+	var comparisons []interface{}
+	for _, phase := range phases {
+		baselineSummary := mmetrics.NewLatencySummary(baselinePhases[phase], phase)
+		candidateSummary := mmetrics.NewLatencySummary(candidatePhases[phase], phase)
+		pValue := mannWhitneyPValue(baselinePhases[phase], candidatePhases[phase])
+		comparison := NodeReadyLatencyComparisonMetric{
+			Timestamp:             time.Now().UTC(),
+			MetricName:            nodeReadyLatencyComparisonMeasurement,
+			UUID:                  uuid,
+			JobName:               JobName,
+			Phase:                 phase,
+			BaselineMachineSet:    baselineMachineSet,
+			BaselineBootImageID:   baselineNode.BootImageID,
+			BaselineInstanceType:  baselineNode.InstanceType,
+			BaselineCount:         len(baselinePhases[phase]),
+			BaselineP99:           baselineSummary.P99,
+			BaselineP50:           baselineSummary.P50,
+			BaselineAvg:           baselineSummary.Avg,
+			CandidateMachineSet:   candidateMachineSet,
+			CandidateBootImageID:  candidateNode.BootImageID,
+			CandidateInstanceType: candidateNode.InstanceType,
+			CandidateCount:        len(candidatePhases[phase]),
+			CandidateP99:          candidateSummary.P99,
+			CandidateP50:          candidateSummary.P50,
+			CandidateAvg:          candidateSummary.Avg,
+			DeltaP99:              candidateSummary.P99 - baselineSummary.P99,
+			DeltaP50:              candidateSummary.P50 - baselineSummary.P50,
+			DeltaAvg:              candidateSummary.Avg - baselineSummary.Avg,
+			PValue:                pValue,
+			Significant:           pValue < comparisonSignificanceLevel,
+			Iteration:             iteration,
+			Metadata:              metadata,
+		}
+		if baselineSummary.Avg != 0 {
+			comparison.DeltaAvgPercent = 100 * float64(comparison.DeltaAvg) / float64(baselineSummary.Avg)
+		}
+		log.Infof("%s: %s baseline avg: %v candidate avg: %v delta: %v (%.1f%%) p-value: %.4f significant: %v", JobName, phase, baselineSummary.Avg, candidateSummary.Avg, comparison.DeltaAvg, comparison.DeltaAvgPercent, pValue, comparison.Significant)
+		comparisons = append(comparisons, comparison)
+	}
+	metricMap := map[string][]interface{}{
+		nodeReadyLatencyComparisonMeasurement: comparisons,
+	}
+	measurements.IndexLatencyMeasurement(mtypes.Measurement{Name: measurementName}, JobName, metricMap, map[string]indexers.Indexer{
+		"": indexerValue,
+	})
+}
+
+// mannWhitneyPValue returns the two-sided p-value of the Mann-Whitney U test, exact for small samples and using the normal approximation with tie and continuity corrections otherwise
+func mannWhitneyPValue(baseline []float64, candidate []float64) float64 {
+	type rankedLatency struct {
+		latency  float64
+		baseline bool
+	}
+	var rankedLatencies []rankedLatency
+	for _, latency := range baseline {
+		rankedLatencies = append(rankedLatencies, rankedLatency{latency: latency, baseline: true})
+	}
+	for _, latency := range candidate {
+		rankedLatencies = append(rankedLatencies, rankedLatency{latency: latency})
+	}
+	sort.Slice(rankedLatencies, func(i, j int) bool {
+		return rankedLatencies[i].latency < rankedLatencies[j].latency
+	})
+	var baselineRankSum, tieCorrection float64
+	for i := 0; i < len(rankedLatencies); {
+		j := i
+		for j < len(rankedLatencies) && rankedLatencies[j].latency == rankedLatencies[i].latency {
+			j++
+		}
+		// Tied latencies share the average of their ranks
+		rank := float64(i+j+1) / 2
+		ties := float64(j - i)
+		tieCorrection += ties*ties*ties - ties
+		for k := i; k < j; k++ {
+			if rankedLatencies[k].baseline {
+				baselineRankSum += rank
+			}
+		}
+		i = j
+	}
+	n1, n2 := float64(len(baseline)), float64(len(candidate))
+	n := n1 + n2
+	u := baselineRankSum - n1*(n1+1)/2
+	if len(baseline)*len(candidate) <= exactMannWhitneyLimit {
+		return exactMannWhitneyPValue(len(baseline), len(candidate), u)
+	}
+	variance := n1 * n2 / 12 * ((n + 1) - tieCorrection/(n*(n-1)))
+	if variance <= 0 {
+		return 1
+	}
+	z := math.Max(math.Abs(u-n1*n2/2)-0.5, 0) / math.Sqrt(variance)
+	return math.Erfc(z / math.Sqrt2)
+}
+
+// exactMannWhitneyPValue returns the two-sided p-value of U from its exact distribution without ties, a U left between two values by ties counts in both tails, keeping the p-value conservative
+func exactMannWhitneyPValue(n1 int, n2 int, u float64) float64 {
+	// counts[j][k] holds the number of orderings of the baseline with j candidate latencies giving U = k
+	counts := make([][]float64, n2+1)
+	for j := range counts {
+		counts[j] = make([]float64, n1*n2+1)
+		counts[j][0] = 1
+	}
+	for i := 1; i <= n1; i++ {
+		next := make([][]float64, n2+1)
+		for j := range next {
+			next[j] = make([]float64, n1*n2+1)
+			for k := range next[j] {
+				// The largest latency either belongs to the baseline, outranking the j candidate ones, or to the candidate
+				if k >= j {
+					next[j][k] += counts[j][k-j]
+				}
+				if j > 0 {
+					next[j][k] += next[j-1][k]
+				}
+			}
+		}
+		counts = next
+	}
+	distribution := counts[n2]
+	var total, lower, upper float64
+	for k, count := range distribution {
+		total += count
+		if float64(k) <= math.Ceil(u) {
+			lower += count
+		}
+		if float64(k) >= math.Floor(u) {
+			upper += count
+		}
+	}
+	return math.Min(1, 2*math.Min(lower, upper)/total)
+}
@@ -0,0 +1,83 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerscale
+
+import (
+	"math"
+	"testing"
+)
+
+// latencies returns count latencies starting from start, one second apart
+func latencies(start float64, count int) []float64 {
+	var values []float64
+	for i := 0; i < count; i++ {
+		values = append(values, start+float64(i)*1000)
+	}
+	return values
+}
+
+func TestMannWhitneyPValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		baseline  []float64
+		candidate []float64
+		expected  float64
+	}{
+		{
+			name:      "3v3 fully separated",
+			baseline:  latencies(1000, 3),
+			candidate: latencies(10000, 3),
+			expected:  0.1,
+		},
+		{
+			name:      "3v3 fully separated the other way",
+			baseline:  latencies(10000, 3),
+			candidate: latencies(1000, 3),
+			expected:  0.1,
+		},
+		{
+			name:      "8v8 fully separated",
+			baseline:  latencies(1000, 8),
+			candidate: latencies(100000, 8),
+			expected:  2.0 / 12870,
+		},
+		{
+			name:      "3v3 interleaved",
+			baseline:  []float64{1000, 3000, 5000},
+			candidate: []float64{2000, 4000, 6000},
+			expected:  0.7,
+		},
+		{
+			name:      "identical samples",
+			baseline:  []float64{1000, 1000, 1000},
+			candidate: []float64{1000, 1000, 1000},
+			expected:  1,
+		},
+		{
+			name:      "21v20 fully separated uses the normal approximation",
+			baseline:  latencies(1000, 21),
+			candidate: latencies(100000, 20),
+			// z = (210 - 0.5) / sqrt(21*20*42/12)
+			expected: math.Erfc((210 - 0.5) / math.Sqrt(21*20*42.0/12) / math.Sqrt2),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mannWhitneyPValue(tt.baseline, tt.candidate); math.Abs(got-tt.expected) > 1e-9 {
+				t.Errorf("got p-value %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
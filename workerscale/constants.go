@@ -18,6 +18,9 @@ import "time"
 
 // Resource constants
 const RosaHCP = "rosa-hcp"
+const ARO = "aro"
+const CAPI = "capi"
+const HyperShift = "hypershift"
 const JobName = "workers-scale"
 const ClusterType = "clusterType"
 const MachineNamespace = "openshift-machine-api"
@@ -25,12 +28,109 @@ const DefaultNamespace = "default"
 const DefaultClusterAutoScaler = "default"
 const AutoScalerBuffer = 10
 
+// Platform constants, as reported by the infrastructure object
+const AWSPlatform = "AWS"
+const AzurePlatform = "Azure"
+const GCPPlatform = "GCP"
+const VSpherePlatform = "VSphere"
+const BareMetalPlatform = "BareMetal"
+
+// Placement strategies to distribute the additional workers across machinesets
+const EvenPlacement = "even"
+const ZoneRoundRobinPlacement = "round-robin"
+const WeightedPlacement = "weighted"
+const SinglePlacement = "single"
+const ProportionalPlacement = "proportional"
+const ExplicitPlacement = "explicit"
+
 // Measurement constants
 const measurementName = "nodeLatency"
 const nodeReadyLatencyMeasurement = "nodeReadyLatencyMeasurement"
 const nodeReadyLatencyQuantilesMeasurement = "nodeReadyLatencyQuantilesMeasurement"
 const nodeReadyLatencyStackedMeasurement = "nodeReadyLatencyStackedMeasurement"
+const nodeReadyLatencyGroupedStackedMeasurement = "nodeReadyLatencyGroupedStackedMeasurement"
+const nodeDeletionLatencyMeasurement = "nodeDeletionLatencyMeasurement"
+const nodeDeletionLatencyQuantilesMeasurement = "nodeDeletionLatencyQuantilesMeasurement"
+const podProbeLatencyMeasurement = "podProbeLatencyMeasurement"
+const podProbeLatencyQuantilesMeasurement = "podProbeLatencyQuantilesMeasurement"
+const failedMachinesMeasurement = "failedMachines"
+const nodeReadyLatencyComparisonMeasurement = "nodeReadyLatencyComparisonMeasurement"
+
+// Stacked measurement groups
+const machineSetGroup = "machineSet"
+const instanceTypeGroup = "instanceType"
+const architectureGroup = "architecture"
+const zoneGroup = "zone"
+const regionGroup = "region"
+
+// Provider status condition constants
+const awsMachineReadyCondition = "MachineCreation"
+const machineCreatedCondition = "MachineCreated"
+const instanceExistsCondition = "InstanceExists"
+const machineDrainedCondition = "Drained"
+
+// Lifecycle events recorded by the cluster watcher
+const machineCreatedEvent = "Created"
+const machineDeletingEvent = "Deleting"
+const machineDrainedEvent = "Drained"
+const machineDeletedEvent = "Deleted"
+const nodeCreatedEvent = "Created"
+const nodeReadyEvent = "Ready"
+const nodeCordonedEvent = "Cordoned"
+const nodeDeletedEvent = "Deleted"
+const machineProvisioningPhase = "Provisioning"
+const machineProvisionedPhase = "Provisioned"
+const machineRunningPhase = "Running"
+const machineFailedPhase = "Failed"
+const machineProviderIDEvent = "ProviderID"
+const nodeNetworkReadyEvent = "NetworkReady"
+const nodeMachineConfigDoneEvent = "MachineConfigDone"
+const nodeClientCSRCreatedEvent = "ClientCSRCreated"
+const nodeClientCSRApprovedEvent = "ClientCSRApproved"
+const nodeServingCSRCreatedEvent = "ServingCSRCreated"
+const nodeServingCSRApprovedEvent = "ServingCSRApproved"
+
+// CSR state reported when neither approved nor denied
+const csrPendingState = "Pending"
+
+// Machine config daemon constants
+const machineConfigStateAnnotation = "machineconfiguration.openshift.io/state"
+const machineConfigDoneState = "Done"
+
+// Metal3 constants
+const bareMetalHostAnnotation = "metal3.io/BareMetalHost"
+
+// Rollback step constants
+const MachineSetsRollback = "machinesets"
+const MachineAutoscalersRollback = "machineautoscalers"
+const ClusterAutoscalerRollback = "clusterautoscaler"
+const BatchJobRollback = "batchjob"
+const MachinePoolsRollback = "machinepools"
+const PodProbeRollback = "podprobe"
+const EphemeralMachineSetRollback = "ephemeralmachineset"
+const NodePoolsRollback = "nodepools"
+const MachineDeploymentsRollback = "machinedeployments"
+
+// Run state constants
+const runStateKey = "state.json"
+const MachineAutoscalerKind = "MachineAutoscaler"
+const ClusterAutoscalerKind = "ClusterAutoscaler"
+const JobKind = "Job"
+const PodKind = "Pod"
+const MachineSetKind = "MachineSet"
+const ephemeralMachineSetLabel = "workers-scale.io/ephemeral"
+const autoscalerNodeGroupAnnotationPrefix = "machine.openshift.io/cluster-api-autoscaler-node-group-"
+
+// Comparison constants
+const baselineVariant = "a"
+const candidateVariant = "b"
+const comparisonSignificanceLevel = 0.05
+const exactMannWhitneyLimit = 400
+
+// Pod probe constants
+const probePodLabel = "workers-scale-probe"
+const probeTimeout = 10 * time.Minute
 
 // Misc constants
-const maxWaitTimeout = 4 * time.Hour
+const cacheSyncTimeout = 5 * time.Minute
 const TenMinutes = 600
@@ -16,6 +16,8 @@ package core
 
 import (
 	"context"
+	goerrors "errors"
+	"fmt"
 	"sync"
 	"time"
 
@@ -38,42 +40,129 @@ import (
 type AutoScalerScenario struct{}
 
 // Returns a new scenario object
-func (awsAutoScalerScenario *AutoScalerScenario) OrchestrateWorkload(scaleConfig wscale.ScaleConfig) string {
+func (awsAutoScalerScenario *AutoScalerScenario) OrchestrateWorkload(ctx context.Context, scaleConfig wscale.ScaleConfig) (string, error) {
 	var err error
 	kubeClientProvider := config.NewKubeClientProvider("", "")
 	clientSet, restConfig := kubeClientProvider.ClientSet(0, 0)
 	dynamicClient := dynamic.NewForConfigOrDie(restConfig)
-	machineClient := wscale.GetMachineClient(restConfig)
-	machineSetDetails := wscale.GetMachinesets(machineClient)
-	prevMachineDetails, _ := wscale.GetMachines(machineClient, 0)
-	machineSetsToEdit := adjustMachineSets(machineSetDetails, scaleConfig.AdditionalWorkerNodes)
+	machineClient, err := wscale.GetMachineClient(restConfig)
+	if err != nil {
+		return "", err
+	}
+	if err = wscale.StartClusterWatcher(ctx, machineClient, clientSet); err != nil {
+		return "", err
+	}
+	defer wscale.StopClusterWatcher()
+	ephemeralMachineSets, err := createEphemeralMachineSets(ctx, machineClient, &scaleConfig)
+	if err != nil {
+		return "", err
+	}
+	machineSetDetails, err := wscale.GetMachinesets(ctx, machineClient, scaleConfig.MachineSetFilter)
+	if err != nil {
+		return "", err
+	}
+	machineSetZones, err := wscale.GetMachineSetZones(ctx, machineClient, scaleConfig.MachineSetFilter)
+	if err != nil {
+		return "", err
+	}
+	prevMachineDetails, _, err := wscale.GetMachines(ctx, machineClient, 0, scaleConfig.Platform, scaleConfig.MachineSetFilter)
+	if err != nil {
+		return "", err
+	}
+	machineSetsToEdit, err := planPlacement(machineSetDetails, machineSetZones, scaleConfig)
+	if err != nil {
+		return "", err
+	}
+	printPlacementPlan(machineSetsToEdit, scaleConfig.PlacementStrategy)
 	wscale.SetupMetrics(scaleConfig.UUID, scaleConfig.Metadata, kubeClientProvider)
 	measurements.Start()
-	createMachineAutoscalers(dynamicClient, machineSetsToEdit)
-	createAutoScaler(dynamicClient, wscale.AutoScalerBuffer+len(prevMachineDetails)+scaleConfig.AdditionalWorkerNodes)
-	triggerJob, triggerTime := CreateBatchJob(clientSet)
+	wscale.RecordMachineSets(machineSetsToEdit)
+	wscale.RegisterRollback(wscale.MachineSetsRollback, func() error {
+		return wscale.RestoreMachineSets(context.Background(), machineClient, machineSetsToEdit)
+	})
+	wscale.RegisterRollback(wscale.MachineAutoscalersRollback, func() error {
+		return deleteMachineAutoscalers(context.Background(), dynamicClient, machineSetsToEdit)
+	})
+	if err = createMachineAutoscalers(ctx, dynamicClient, machineSetsToEdit); err != nil {
+		return "", err
+	}
+	wscale.RegisterRollback(wscale.ClusterAutoscalerRollback, func() error {
+		return deleteAutoScaler(context.Background(), dynamicClient)
+	})
+	if err = createAutoScaler(ctx, dynamicClient, wscale.AutoScalerBuffer+len(prevMachineDetails)+scaleConfig.AdditionalWorkerNodes); err != nil {
+		return "", err
+	}
+	podProbe, err := wscale.StartPodProbe(ctx, clientSet, scaleConfig.ProbeImage)
+	if err != nil {
+		return "", err
+	}
+	defer podProbe.Cancel()
+	triggerJob, triggerTime, err := CreateBatchJob(ctx, clientSet)
+	if err != nil {
+		return "", err
+	}
+	wscale.RegisterRollback(wscale.BatchJobRollback, func() error {
+		return DeleteBatchJob(context.Background(), clientSet, triggerJob)
+	})
+	scaleUpCtx, cancel := context.WithTimeout(ctx, scaleConfig.ScaleUpTimeout)
+	defer cancel()
 	// Delay for the clusterautoscaler resources to come up
-	time.Sleep(5 * time.Minute)
-	waitForMachineSets(machineClient, clientSet, machineSetsToEdit, triggerTime)
+	if err = wscale.Sleep(scaleUpCtx, 5*time.Minute); err != nil {
+		return "", err
+	}
+	if err = waitForMachineSets(scaleUpCtx, machineClient, clientSet, machineSetsToEdit, triggerTime); err != nil {
+		return "", wscale.CheckFailedMachines(ctx, machineClient, clientSet, triggerTime, scaleConfig, err)
+	}
 	if err = measurements.Stop(); err != nil {
-		log.Fatal(err.Error())
+		return "", err
+	}
+	scaledMachineDetails, amiID, err := wscale.GetMachines(ctx, machineClient, 0, scaleConfig.Platform, scaleConfig.MachineSetFilter)
+	if err != nil {
+		return "", err
 	}
-	scaledMachineDetails, amiID := wscale.GetMachines(machineClient, 0)
 	wscale.DiscardPreviousMachines(prevMachineDetails, scaledMachineDetails)
-	wscale.FinalizeMetrics(machineSetsToEdit, scaledMachineDetails, scaleConfig.Metadata, scaleConfig.Indexer, amiID, 0)
-	deleteAutoScaler(dynamicClient)
-	deleteMachineAutoscalers(dynamicClient, machineSetsToEdit)
-	DeleteBatchJob(clientSet, triggerJob)
+	if err = wscale.GetLifecycleTimelines(ctx, clientSet, scaledMachineDetails); err != nil {
+		return "", err
+	}
+	if scaleConfig.Platform == wscale.BareMetalPlatform {
+		if err = wscale.GetBareMetalHostPhases(ctx, dynamicClient, scaledMachineDetails); err != nil {
+			return "", err
+		}
+	}
+	probeResults := podProbe.Stop(ctx)
+	wscale.FinalizeMetrics(machineSetsToEdit, scaledMachineDetails, scaleConfig.Metadata, scaleConfig.Indexer, 0, scaleConfig.Iteration)
+	wscale.FinalizePodProbeMetrics(scaleConfig.UUID, scaleConfig.ProbeImage, probeResults, scaleConfig.Metadata, scaleConfig.Indexer, scaleConfig.Iteration)
+	if err = deleteAutoScaler(ctx, dynamicClient); err != nil {
+		return "", err
+	}
+	wscale.UnregisterRollback(wscale.ClusterAutoscalerRollback)
+	if err = deleteMachineAutoscalers(ctx, dynamicClient, machineSetsToEdit); err != nil {
+		return "", err
+	}
+	wscale.UnregisterRollback(wscale.MachineAutoscalersRollback)
+	if err = DeleteBatchJob(ctx, clientSet, triggerJob); err != nil {
+		return "", err
+	}
+	wscale.UnregisterRollback(wscale.BatchJobRollback)
 	if scaleConfig.GC {
-		log.Info("Restoring machine sets to previous state")
-		wscale.EditMachineSets(machineClient, clientSet, machineSetsToEdit, false)
+		if err = restoreMachineSets(ctx, machineClient, clientSet, machineSetsToEdit, scaleConfig); err != nil {
+			return "", err
+		}
+		if err = deleteEphemeralMachineSets(ctx, machineClient, ephemeralMachineSets); err != nil {
+			return "", err
+		}
+	} else if len(ephemeralMachineSets) > 0 {
+		log.Infof("Keeping ephemeral machinesets %v, use the cleanup command to delete them", ephemeralMachineSets)
 	}
+	wscale.UnregisterRollback(wscale.MachineSetsRollback)
+	wscale.UnregisterRollback(wscale.EphemeralMachineSetRollback)
+	wscale.CompleteRunState(scaleConfig.GC)
 
-	return amiID
+	return amiID, nil
 }
 
 // CreateBatchJob creates a job to load the cluster
-func CreateBatchJob(clientset kubernetes.Interface) (string, time.Time) {
+func CreateBatchJob(ctx context.Context, clientset kubernetes.Interface) (string, time.Time, error) {
 	job := &batchv1.Job{
 		ObjectMeta: metav1.ObjectMeta{
 			GenerateName: "work-queue-",
@@ -105,35 +194,40 @@ func CreateBatchJob(clientset kubernetes.Interface) (string, time.Time) {
 
 	jobsClient := clientset.BatchV1().Jobs(wscale.DefaultNamespace)
 	triggerTime := time.Now().UTC().Truncate(time.Second)
-	createdJob, err := jobsClient.Create(context.TODO(), job, metav1.CreateOptions{})
+	createdJob, err := jobsClient.Create(ctx, job, metav1.CreateOptions{})
 	if err != nil {
-		log.Fatalf("error creating Job: %s", err)
+		return "", triggerTime, fmt.Errorf("error creating Job: %s", err)
 	}
 
+	wscale.RecordCreatedResource(wscale.JobKind, createdJob.Name)
 	log.Infof("Job created: %s", createdJob.Name)
-	return createdJob.Name, triggerTime
+	return createdJob.Name, triggerTime, nil
 }
 
 // Deletes our batch job that creates load
-func DeleteBatchJob(clientset kubernetes.Interface, jobName string) {
+func DeleteBatchJob(ctx context.Context, clientset kubernetes.Interface, jobName string) error {
 	jobsClient := clientset.BatchV1().Jobs(wscale.DefaultNamespace)
 	deletePolicy := metav1.DeletePropagationForeground
-	err := jobsClient.Delete(context.TODO(), jobName, metav1.DeleteOptions{
+	err := jobsClient.Delete(ctx, jobName, metav1.DeleteOptions{
 		PropagationPolicy: &deletePolicy,
 	})
 	if err != nil {
 		if errors.IsNotFound(err) {
 			log.Infof("Job %s not found in namespace %s", jobName, wscale.DefaultNamespace)
-			return
+			wscale.ForgetCreatedResource(wscale.JobKind, jobName)
+			return nil
 		}
-		log.Fatalf("Error deleting Job %s: %v", jobName, err)
+		return fmt.Errorf("error deleting Job %s: %v", jobName, err)
 	}
+	wscale.ForgetCreatedResource(wscale.JobKind, jobName)
 
 	log.Infof("Job %s deleted successfully in namespace %s", jobName, wscale.DefaultNamespace)
+	return nil
 }
 
 // createMachineAutoscalers will create the autoscalers at machine level
-func createMachineAutoscalers(dynamicClient dynamic.Interface, machineSetsToEdit *sync.Map) {
+func createMachineAutoscalers(ctx context.Context, dynamicClient dynamic.Interface, machineSetsToEdit *sync.Map) error {
+	var createErr error
 	machineSetsToEdit.Range(func(key, value interface{}) bool {
 		machineSet := key.(string)
 		msInfo := value.(wscale.MachineSetInfo)
@@ -162,61 +256,73 @@ func createMachineAutoscalers(dynamicClient dynamic.Interface, machineSetsToEdit
 				},
 			},
 		}
-		_, err := dynamicClient.Resource(gvr).Namespace(wscale.MachineNamespace).Create(context.TODO(), machineAutoscaler, metav1.CreateOptions{})
+		wscale.RecordCreatedResource(wscale.MachineAutoscalerKind, machineSet)
+		_, err := dynamicClient.Resource(gvr).Namespace(wscale.MachineNamespace).Create(ctx, machineAutoscaler, metav1.CreateOptions{})
 		if err != nil {
 			if errors.IsAlreadyExists(err) {
 				log.Infof("machine autoscaler resource %s already exists", machineSet)
-				existingAutoscaler, err := dynamicClient.Resource(gvr).Namespace(wscale.MachineNamespace).Get(context.TODO(), machineSet, metav1.GetOptions{})
+				existingAutoscaler, err := dynamicClient.Resource(gvr).Namespace(wscale.MachineNamespace).Get(ctx, machineSet, metav1.GetOptions{})
 				if err != nil {
-					log.Fatalf("failed to get MachineAutoscaler: %v", err)
+					createErr = fmt.Errorf("failed to get MachineAutoscaler: %v", err)
+					return false
 				}
 				existingAutoscaler.Object["spec"] = machineAutoscaler.Object["spec"]
-				_, err = dynamicClient.Resource(gvr).Namespace(wscale.MachineNamespace).Update(context.TODO(), existingAutoscaler, metav1.UpdateOptions{})
+				_, err = dynamicClient.Resource(gvr).Namespace(wscale.MachineNamespace).Update(ctx, existingAutoscaler, metav1.UpdateOptions{})
 				if err != nil {
-					log.Fatalf("failed to update MachineAutoscaler: %v", err)
+					createErr = fmt.Errorf("failed to update MachineAutoscaler: %v", err)
+					return false
 				}
 				log.Infof("MachineAutoscaler updated: %v", machineSet)
 				return true
 			} else {
-				log.Fatalf("failed to create MachineAutoscaler: %v", err)
+				createErr = fmt.Errorf("failed to create MachineAutoscaler: %v", err)
+				return false
 			}
 		}
 
 		log.Infof("MachineAutoscaler created: %v", machineSet)
 		return true
 	})
+	return createErr
 }
 
 // deleteMachineAutoscalers deletes the MachineAutoscaler resources for the provided machine sets
-func deleteMachineAutoscalers(dynamicClient dynamic.Interface, machineSetsToEdit *sync.Map) {
+func deleteMachineAutoscalers(ctx context.Context, dynamicClient dynamic.Interface, machineSetsToEdit *sync.Map) error {
+	var deleteErr error
 	machineSetsToEdit.Range(func(key, value interface{}) bool {
-		machineSet := key.(string)
+		deleteErr = deleteMachineAutoscaler(ctx, dynamicClient, key.(string))
+		return deleteErr == nil
+	})
+	return deleteErr
+}
 
-		// Define the GroupVersionResource for the MachineAutoscaler
-		gvr := schema.GroupVersionResource{
-			Group:    "autoscaling.openshift.io",
-			Version:  "v1beta1",
-			Resource: "machineautoscalers",
-		}
+// deleteMachineAutoscaler deletes the MachineAutoscaler resource of a machine set
+func deleteMachineAutoscaler(ctx context.Context, dynamicClient dynamic.Interface, machineSet string) error {
+	// Define the GroupVersionResource for the MachineAutoscaler
+	gvr := schema.GroupVersionResource{
+		Group:    "autoscaling.openshift.io",
+		Version:  "v1beta1",
+		Resource: "machineautoscalers",
+	}
 
-		// Attempt to delete the MachineAutoscaler for the machineSet
-		err := dynamicClient.Resource(gvr).Namespace(wscale.MachineNamespace).Delete(context.TODO(), machineSet, metav1.DeleteOptions{})
-		if err != nil {
-			if errors.IsNotFound(err) {
-				log.Infof("Machine Autoscaler %s not found", machineSet)
-				return true
-			} else {
-				log.Fatalf("failed to delete MachineAutoscaler: %v", err)
-			}
+	// Attempt to delete the MachineAutoscaler for the machineSet
+	err := dynamicClient.Resource(gvr).Namespace(wscale.MachineNamespace).Delete(ctx, machineSet, metav1.DeleteOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			log.Infof("Machine Autoscaler %s not found", machineSet)
+			wscale.ForgetCreatedResource(wscale.MachineAutoscalerKind, machineSet)
+			return nil
 		}
+		return fmt.Errorf("failed to delete MachineAutoscaler: %v", err)
+	}
 
-		log.Infof("Machine Autoscaler %s deleted successfully", machineSet)
-		return true
-	})
+	wscale.ForgetCreatedResource(wscale.MachineAutoscalerKind, machineSet)
+	log.Infof("Machine Autoscaler %s deleted successfully", machineSet)
+	return nil
 }
 
 // createAutoScaler creates the autoscaler resource on the cluster
-func createAutoScaler(dynamicClient dynamic.Interface, maxNodesTotal int) {
+func createAutoScaler(ctx context.Context, dynamicClient dynamic.Interface, maxNodesTotal int) error {
 	gvr := schema.GroupVersionResource{
 		Group:    "autoscaling.openshift.io",
 		Version:  "v1",
@@ -242,31 +348,33 @@ func createAutoScaler(dynamicClient dynamic.Interface, maxNodesTotal int) {
 		},
 	}
 
-	_, err := dynamicClient.Resource(gvr).Namespace("").Create(context.TODO(), clusterAutoscaler, metav1.CreateOptions{})
+	wscale.RecordCreatedResource(wscale.ClusterAutoscalerKind, wscale.DefaultClusterAutoScaler)
+	_, err := dynamicClient.Resource(gvr).Namespace("").Create(ctx, clusterAutoscaler, metav1.CreateOptions{})
 	if err != nil {
 		if errors.IsAlreadyExists(err) {
 			log.Infof("cluster autoscaler resource %s already exists", wscale.DefaultClusterAutoScaler)
-			existingAutoscaler, err := dynamicClient.Resource(gvr).Namespace("").Get(context.TODO(), wscale.DefaultClusterAutoScaler, metav1.GetOptions{})
+			existingAutoscaler, err := dynamicClient.Resource(gvr).Namespace("").Get(ctx, wscale.DefaultClusterAutoScaler, metav1.GetOptions{})
 			if err != nil {
-				log.Fatalf("failed to get ClusterAutoscaler: %v", err)
+				return fmt.Errorf("failed to get ClusterAutoscaler: %v", err)
 			}
 			existingAutoscaler.Object["spec"] = clusterAutoscaler.Object["spec"]
-			_, err = dynamicClient.Resource(gvr).Namespace("").Update(context.TODO(), existingAutoscaler, metav1.UpdateOptions{})
+			_, err = dynamicClient.Resource(gvr).Namespace("").Update(ctx, existingAutoscaler, metav1.UpdateOptions{})
 			if err != nil {
-				log.Fatalf("failed to update ClusterAutoscaler: %v", err)
+				return fmt.Errorf("failed to update ClusterAutoscaler: %v", err)
 			}
 			log.Infof("Cluster Autoscaler updated: %v", wscale.DefaultClusterAutoScaler)
-			return
+			return nil
 		} else {
-			log.Fatalf("failed to create ClusterAutoscaler: %v", err)
+			return fmt.Errorf("failed to create ClusterAutoscaler: %v", err)
 		}
 	}
 
 	log.Infof("Cluster Autoscaler created: %v", wscale.DefaultClusterAutoScaler)
+	return nil
 }
 
 // deleteAutoScaler deletes the ClusterAutoscaler resource on the cluster by its name
-func deleteAutoScaler(dynamicClient dynamic.Interface) {
+func deleteAutoScaler(ctx context.Context, dynamicClient dynamic.Interface) error {
 	gvr := schema.GroupVersionResource{
 		Group:    "autoscaling.openshift.io",
 		Version:  "v1",
@@ -274,22 +382,27 @@ func deleteAutoScaler(dynamicClient dynamic.Interface) {
 	}
 
 	// Delete the ClusterAutoscaler
-	err := dynamicClient.Resource(gvr).Namespace("").Delete(context.TODO(), wscale.DefaultClusterAutoScaler, metav1.DeleteOptions{})
+	err := dynamicClient.Resource(gvr).Namespace("").Delete(ctx, wscale.DefaultClusterAutoScaler, metav1.DeleteOptions{})
 	if err != nil {
 		if errors.IsNotFound(err) {
 			log.Infof("Cluster Autoscaler %s not found", wscale.DefaultClusterAutoScaler)
-			return
+			wscale.ForgetCreatedResource(wscale.ClusterAutoscalerKind, wscale.DefaultClusterAutoScaler)
+			return nil
 		} else {
-			log.Fatalf("failed to delete ClusterAutoscaler: %v", err)
+			return fmt.Errorf("failed to delete ClusterAutoscaler: %v", err)
 		}
 	}
+	wscale.ForgetCreatedResource(wscale.ClusterAutoscalerKind, wscale.DefaultClusterAutoScaler)
 
 	log.Infof("Cluster Autoscaler %s deleted successfully", wscale.DefaultClusterAutoScaler)
+	return nil
 }
 
 // Wait for machinesets to get ready
-func waitForMachineSets(machineClient *machinev1beta1.MachineV1beta1Client, clientSet kubernetes.Interface, machineSetsToEdit *sync.Map, triggerTime time.Time) {
+func waitForMachineSets(ctx context.Context, machineClient *machinev1beta1.MachineV1beta1Client, clientSet kubernetes.Interface, machineSetsToEdit *sync.Map, triggerTime time.Time) error {
 	var wg sync.WaitGroup
+	var errsLock sync.Mutex
+	var errs []error
 	machineSetsToEdit.Range(func(key, value interface{}) bool {
 		machineSet := key.(string)
 		msInfo := value.(wscale.MachineSetInfo)
@@ -298,16 +411,22 @@ func waitForMachineSets(machineClient *machinev1beta1.MachineV1beta1Client, clie
 		wg.Add(1)
 		go func(ms string, r int) {
 			defer wg.Done()
-			err := wscale.WaitForMachineSet(machineClient, ms, int32(r))
+			err := wscale.WaitForMachineSet(ctx, machineClient, ms, int32(r))
 			if err != nil {
-				log.Fatalf("Failed waiting for MachineSet %s: %v", ms, err)
+				errsLock.Lock()
+				errs = append(errs, fmt.Errorf("failed waiting for MachineSet %s: %v", ms, err))
+				errsLock.Unlock()
 			}
 		}(machineSet, msInfo.CurrentReplicas)
 		return true
 	})
 	wg.Wait()
+	if len(errs) > 0 {
+		return goerrors.Join(errs...)
+	}
 	log.Infof("All the machinesets have been scaled")
-	if err := wscale.WaitForNodes(clientSet); err != nil {
-		log.Fatalf("Error waiting for nodes: %v", err)
+	if err := wscale.WaitForNodes(ctx, clientSet); err != nil {
+		return fmt.Errorf("error waiting for nodes: %v", err)
 	}
+	return nil
 }
@@ -15,56 +15,188 @@
 package core
 
 import (
+	"context"
+	"fmt"
 	"sort"
 	"sync"
+	"time"
 
 	"github.com/kube-burner/kube-burner/pkg/config"
 	"github.com/kube-burner/kube-burner/pkg/measurements"
+	machinev1beta1 "github.com/openshift/client-go/machine/clientset/versioned/typed/machine/v1beta1"
 	log "github.com/sirupsen/logrus"
 	wscale "github.com/vishnuchalla/workers-scale/workerscale"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
 )
 
 type BaseScenario struct{}
 
 // Returns a new scenario object
-func (awsScenario *BaseScenario) OrchestrateWorkload(scaleConfig wscale.ScaleConfig) string {
+func (awsScenario *BaseScenario) OrchestrateWorkload(ctx context.Context, scaleConfig wscale.ScaleConfig) (string, error) {
 	var err error
 	kubeClientProvider := config.NewKubeClientProvider("", "")
 	clientSet, restConfig := kubeClientProvider.ClientSet(0, 0)
-	machineClient := wscale.GetMachineClient(restConfig)
+	machineClient, err := wscale.GetMachineClient(restConfig)
+	if err != nil {
+		return "", err
+	}
+	dynamicClient := dynamic.NewForConfigOrDie(restConfig)
+	if err = wscale.StartClusterWatcher(ctx, machineClient, clientSet); err != nil {
+		return "", err
+	}
+	defer wscale.StopClusterWatcher()
 	if scaleConfig.ScaleEventEpoch != 0 {
 		log.Info("Scale event epoch time specified. Hence calculating node latencies without any scaling")
 		wscale.SetupMetrics(scaleConfig.UUID, scaleConfig.Metadata, kubeClientProvider)
 		measurements.Start()
-		if err := wscale.WaitForNodes(clientSet); err != nil {
-			log.Fatalf("Error waiting for nodes: %v", err)
+		scaleUpCtx, cancel := context.WithTimeout(ctx, scaleConfig.ScaleUpTimeout)
+		defer cancel()
+		if err := wscale.WaitForNodes(scaleUpCtx, clientSet); err != nil {
+			return "", wscale.CheckFailedMachines(ctx, machineClient, clientSet, time.Unix(scaleConfig.ScaleEventEpoch, 0).UTC(), scaleConfig, fmt.Errorf("error waiting for nodes: %v", err))
 		}
 		if err = measurements.Stop(); err != nil {
-			log.Fatal(err.Error())
+			return "", err
+		}
+		scaledMachineDetails, amiID, err := wscale.GetMachines(ctx, machineClient, scaleConfig.ScaleEventEpoch, scaleConfig.Platform, scaleConfig.MachineSetFilter)
+		if err != nil {
+			return "", err
 		}
-		scaledMachineDetails, amiID := wscale.GetMachines(machineClient, scaleConfig.ScaleEventEpoch)
-		wscale.FinalizeMetrics(&sync.Map{}, scaledMachineDetails, scaleConfig.Metadata, scaleConfig.Indexer, amiID, scaleConfig.ScaleEventEpoch)
-		return amiID
+		if err = wscale.GetLifecycleTimelines(ctx, clientSet, scaledMachineDetails); err != nil {
+			return "", err
+		}
+		if scaleConfig.Platform == wscale.BareMetalPlatform {
+			if err = wscale.GetBareMetalHostPhases(ctx, dynamicClient, scaledMachineDetails); err != nil {
+				return "", err
+			}
+		}
+		wscale.FinalizeMetrics(&sync.Map{}, scaledMachineDetails, scaleConfig.Metadata, scaleConfig.Indexer, scaleConfig.ScaleEventEpoch, scaleConfig.Iteration)
+		return amiID, nil
 	} else {
-		machineSetDetails := wscale.GetMachinesets(machineClient)
-		prevMachineDetails, _ := wscale.GetMachines(machineClient, 0)
+		ephemeralMachineSets, err := createEphemeralMachineSets(ctx, machineClient, &scaleConfig)
+		if err != nil {
+			return "", err
+		}
+		machineSetDetails, err := wscale.GetMachinesets(ctx, machineClient, scaleConfig.MachineSetFilter)
+		if err != nil {
+			return "", err
+		}
+		machineSetZones, err := wscale.GetMachineSetZones(ctx, machineClient, scaleConfig.MachineSetFilter)
+		if err != nil {
+			return "", err
+		}
+		prevMachineDetails, _, err := wscale.GetMachines(ctx, machineClient, 0, scaleConfig.Platform, scaleConfig.MachineSetFilter)
+		if err != nil {
+			return "", err
+		}
+		machineSetsToEdit, err := planPlacement(machineSetDetails, machineSetZones, scaleConfig)
+		if err != nil {
+			return "", err
+		}
+		printPlacementPlan(machineSetsToEdit, scaleConfig.PlacementStrategy)
 		wscale.SetupMetrics(scaleConfig.UUID, scaleConfig.Metadata, kubeClientProvider)
 		measurements.Start()
-		machineSetsToEdit := adjustMachineSets(machineSetDetails, scaleConfig.AdditionalWorkerNodes)
-		log.Info("Updating machinessets evenly to reach desired count")
-		wscale.EditMachineSets(machineClient, clientSet, machineSetsToEdit, true)
+		log.Info("Updating machinesets to reach desired count")
+		wscale.RecordMachineSets(machineSetsToEdit)
+		wscale.RegisterRollback(wscale.MachineSetsRollback, func() error {
+			return wscale.RestoreMachineSets(context.Background(), machineClient, machineSetsToEdit)
+		})
+		podProbe, err := wscale.StartPodProbe(ctx, clientSet, scaleConfig.ProbeImage)
+		if err != nil {
+			return "", err
+		}
+		defer podProbe.Cancel()
+		scaleUpCtx, cancel := context.WithTimeout(ctx, scaleConfig.ScaleUpTimeout)
+		defer cancel()
+		scaleUpTimestamp := time.Now().UTC().Truncate(time.Second)
+		if err = wscale.EditMachineSets(scaleUpCtx, machineClient, clientSet, machineSetsToEdit, true); err != nil {
+			return "", wscale.CheckFailedMachines(ctx, machineClient, clientSet, scaleUpTimestamp, scaleConfig, err)
+		}
 		if err = measurements.Stop(); err != nil {
-			log.Fatal(err.Error())
+			return "", err
+		}
+		scaledMachineDetails, amiID, err := wscale.GetMachines(ctx, machineClient, 0, scaleConfig.Platform, scaleConfig.MachineSetFilter)
+		if err != nil {
+			return "", err
 		}
-		scaledMachineDetails, amiID := wscale.GetMachines(machineClient, 0)
 		wscale.DiscardPreviousMachines(prevMachineDetails, scaledMachineDetails)
-		wscale.FinalizeMetrics(machineSetsToEdit, scaledMachineDetails, scaleConfig.Metadata, scaleConfig.Indexer, amiID, scaleConfig.ScaleEventEpoch)
+		if err = wscale.GetLifecycleTimelines(ctx, clientSet, scaledMachineDetails); err != nil {
+			return "", err
+		}
+		if scaleConfig.Platform == wscale.BareMetalPlatform {
+			if err = wscale.GetBareMetalHostPhases(ctx, dynamicClient, scaledMachineDetails); err != nil {
+				return "", err
+			}
+		}
+		probeResults := podProbe.Stop(ctx)
+		nodeReadyLatencies := wscale.FinalizeMetrics(machineSetsToEdit, scaledMachineDetails, scaleConfig.Metadata, scaleConfig.Indexer, scaleConfig.ScaleEventEpoch, scaleConfig.Iteration)
+		if scaleConfig.EphemeralMachineSet.IsComparison() {
+			wscale.FinalizeComparisonMetrics(scaleConfig.UUID, ephemeralMachineSets[0], ephemeralMachineSets[1], nodeReadyLatencies, scaleConfig.Metadata, scaleConfig.Indexer, scaleConfig.Iteration)
+		}
+		wscale.FinalizePodProbeMetrics(scaleConfig.UUID, scaleConfig.ProbeImage, probeResults, scaleConfig.Metadata, scaleConfig.Indexer, scaleConfig.Iteration)
 		if scaleConfig.GC {
-			log.Info("Restoring machine sets to previous state")
-			wscale.EditMachineSets(machineClient, clientSet, machineSetsToEdit, false)
+			if err = restoreMachineSets(ctx, machineClient, clientSet, machineSetsToEdit, scaleConfig); err != nil {
+				return "", err
+			}
+			if err = deleteEphemeralMachineSets(ctx, machineClient, ephemeralMachineSets); err != nil {
+				return "", err
+			}
+		} else if len(ephemeralMachineSets) > 0 {
+			log.Infof("Keeping ephemeral machinesets %v, use the cleanup command to delete them", ephemeralMachineSets)
 		}
-		return amiID
+		wscale.UnregisterRollback(wscale.MachineSetsRollback)
+		wscale.UnregisterRollback(wscale.EphemeralMachineSetRollback)
+		wscale.CompleteRunState(scaleConfig.GC)
+		return amiID, nil
+	}
+}
+
+// createEphemeralMachineSets clones the ephemeral machinesets when requested, placing the same number of workers on every compared variant
+func createEphemeralMachineSets(ctx context.Context, machineClient *machinev1beta1.MachineV1beta1Client, scaleConfig *wscale.ScaleConfig) ([]string, error) {
+	if scaleConfig.EphemeralMachineSet.Template == "" {
+		return nil, nil
+	}
+	ephemeralMachineSets, err := wscale.CreateEphemeralMachineSets(ctx, machineClient, *scaleConfig)
+	if err != nil {
+		return nil, err
+	}
+	// Only the clones are scaled, measured and waited on, for this run alone
+	if scaleConfig.MachineSetFilter, err = wscale.NewMachineSetFilter("", ephemeralMachineSets, nil); err != nil {
+		return nil, err
+	}
+	if scaleConfig.EphemeralMachineSet.IsComparison() {
+		// Every variant is scaled by the additional workers, the even placement splits them equally across the empty clones
+		scaleConfig.AdditionalWorkerNodes *= len(ephemeralMachineSets)
+	}
+	return ephemeralMachineSets, nil
+}
+
+// deleteEphemeralMachineSets deletes the ephemeral machinesets along with their machines
+func deleteEphemeralMachineSets(ctx context.Context, machineClient *machinev1beta1.MachineV1beta1Client, ephemeralMachineSets []string) error {
+	for _, machineSet := range ephemeralMachineSets {
+		if err := wscale.DeleteEphemeralMachineSet(ctx, machineClient, machineSet); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreMachineSets restores the machinesets to their previous replicas, measuring the scale down
+func restoreMachineSets(ctx context.Context, machineClient *machinev1beta1.MachineV1beta1Client, clientSet kubernetes.Interface, machineSetsToEdit *sync.Map, scaleConfig wscale.ScaleConfig) error {
+	log.Info("Restoring machine sets to previous state")
+	scaleDownCtx, cancel := context.WithTimeout(ctx, scaleConfig.ScaleDownTimeout)
+	defer cancel()
+	scaleDownTracker, err := wscale.NewScaleDownTracker(scaleDownCtx, machineClient, clientSet, scaleConfig.MachineSetFilter)
+	if err != nil {
+		return err
+	}
+	err = wscale.EditMachineSets(scaleDownCtx, machineClient, clientSet, machineSetsToEdit, false)
+	deletedMachines := scaleDownTracker.Stop(scaleDownCtx)
+	if err != nil {
+		return err
 	}
+	wscale.FinalizeScaleDownMetrics(scaleConfig.UUID, machineSetsToEdit, deletedMachines, scaleConfig.Metadata, scaleConfig.Indexer, scaleConfig.Iteration)
+	return nil
 }
 
 // adjustMachineSets equally spreads requested number of machines across machinesets
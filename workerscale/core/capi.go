@@ -0,0 +1,131 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/config"
+	"github.com/kube-burner/kube-burner/pkg/measurements"
+	log "github.com/sirupsen/logrus"
+	wscale "github.com/vishnuchalla/workers-scale/workerscale"
+)
+
+type CAPIScenario struct{}
+
+// Returns a new scenario object
+func (capiScenario *CAPIScenario) OrchestrateWorkload(ctx context.Context, scaleConfig wscale.ScaleConfig) (string, error) {
+	var err error
+	if scaleConfig.AutoScalerEnabled {
+		return "", fmt.Errorf("autoscaler is not supported with cluster api machinedeployments")
+	}
+	if scaleConfig.MCKubeConfig == "" {
+		return "", fmt.Errorf("error reading management cluster kubeconfig. Please provide a valid path")
+	}
+	kubeClientProvider := config.NewKubeClientProvider("", "")
+	clientSet, _ := kubeClientProvider.ClientSet(0, 0)
+	mcKubeClientProvider := config.NewKubeClientProvider(scaleConfig.MCKubeConfig, "")
+	mcClientSet, mcRestConfig := mcKubeClientProvider.ClientSet(0, 0)
+	capiClient, err := wscale.GetCAPIClient(mcRestConfig)
+	if err != nil {
+		return "", err
+	}
+	if scaleConfig.ScaleEventEpoch != 0 {
+		log.Info("Scale event epoch time specified. Hence calculating node latencies without any scaling")
+		wscale.SetupMetrics(scaleConfig.UUID, scaleConfig.Metadata, kubeClientProvider)
+		measurements.Start()
+		scaleUpCtx, cancel := context.WithTimeout(ctx, scaleConfig.ScaleUpTimeout)
+		defer cancel()
+		if err := wscale.WaitForNodes(scaleUpCtx, clientSet); err != nil {
+			return "", wscale.CheckFailedCAPIMachines(ctx, capiClient, mcClientSet, scaleConfig.CAPIClusterName, scaleConfig.CAPINamespace, time.Unix(scaleConfig.ScaleEventEpoch, 0).UTC(), scaleConfig, fmt.Errorf("error waiting for nodes: %v", err))
+		}
+		if err = measurements.Stop(); err != nil {
+			return "", err
+		}
+		scaledMachineDetails, amiID, err := wscale.GetCapiMachines(ctx, capiClient, scaleConfig.ScaleEventEpoch, scaleConfig.CAPIClusterName, scaleConfig.CAPINamespace, scaleConfig.MachineSetFilter)
+		if err != nil {
+			return "", err
+		}
+		if err = wscale.GetLifecycleTimelines(ctx, clientSet, scaledMachineDetails); err != nil {
+			return "", err
+		}
+		wscale.FinalizeMetrics(&sync.Map{}, scaledMachineDetails, scaleConfig.Metadata, scaleConfig.Indexer, scaleConfig.ScaleEventEpoch, scaleConfig.Iteration)
+		return amiID, nil
+	} else {
+		machineDeploymentDetails, err := wscale.GetCAPIMachineDeployments(ctx, capiClient, scaleConfig.CAPIClusterName, scaleConfig.CAPINamespace, scaleConfig.MachineSetFilter)
+		if err != nil {
+			return "", err
+		}
+		machineDeploymentZones, err := wscale.GetCAPIMachineDeploymentZones(ctx, capiClient, scaleConfig.CAPIClusterName, scaleConfig.CAPINamespace, scaleConfig.MachineSetFilter)
+		if err != nil {
+			return "", err
+		}
+		prevMachineDetails, _, err := wscale.GetCapiMachines(ctx, capiClient, 0, scaleConfig.CAPIClusterName, scaleConfig.CAPINamespace, scaleConfig.MachineSetFilter)
+		if err != nil {
+			return "", err
+		}
+		machineDeploymentsToEdit, err := planPlacement(machineDeploymentDetails, machineDeploymentZones, scaleConfig)
+		if err != nil {
+			return "", err
+		}
+		printPlacementPlan(machineDeploymentsToEdit, scaleConfig.PlacementStrategy)
+		wscale.SetupMetrics(scaleConfig.UUID, scaleConfig.Metadata, kubeClientProvider)
+		measurements.Start()
+		log.Info("Updating machinedeployments to reach desired count")
+		podProbe, err := wscale.StartPodProbe(ctx, clientSet, scaleConfig.ProbeImage)
+		if err != nil {
+			return "", err
+		}
+		defer podProbe.Cancel()
+		scaleUpCtx, cancel := context.WithTimeout(ctx, scaleConfig.ScaleUpTimeout)
+		defer cancel()
+		wscale.RecordMachineDeployments(scaleConfig.MCKubeConfig, scaleConfig.CAPINamespace, machineDeploymentsToEdit)
+		wscale.RegisterRollback(wscale.MachineDeploymentsRollback, func() error {
+			return wscale.RestoreCAPIMachineDeployments(context.Background(), capiClient, scaleConfig.CAPINamespace, machineDeploymentsToEdit)
+		})
+		scaleUpTimestamp := time.Now().UTC().Truncate(time.Second)
+		if err = wscale.EditCAPIMachineDeployments(scaleUpCtx, capiClient, clientSet, scaleConfig.CAPINamespace, machineDeploymentsToEdit, true); err != nil {
+			return "", wscale.CheckFailedCAPIMachines(ctx, capiClient, mcClientSet, scaleConfig.CAPIClusterName, scaleConfig.CAPINamespace, scaleUpTimestamp, scaleConfig, err)
+		}
+		if err = measurements.Stop(); err != nil {
+			return "", err
+		}
+		scaledMachineDetails, amiID, err := wscale.GetCapiMachines(ctx, capiClient, 0, scaleConfig.CAPIClusterName, scaleConfig.CAPINamespace, scaleConfig.MachineSetFilter)
+		if err != nil {
+			return "", err
+		}
+		wscale.DiscardPreviousMachines(prevMachineDetails, scaledMachineDetails)
+		if err = wscale.GetLifecycleTimelines(ctx, clientSet, scaledMachineDetails); err != nil {
+			return "", err
+		}
+		probeResults := podProbe.Stop(ctx)
+		wscale.FinalizeMetrics(machineDeploymentsToEdit, scaledMachineDetails, scaleConfig.Metadata, scaleConfig.Indexer, scaleConfig.ScaleEventEpoch, scaleConfig.Iteration)
+		wscale.FinalizePodProbeMetrics(scaleConfig.UUID, scaleConfig.ProbeImage, probeResults, scaleConfig.Metadata, scaleConfig.Indexer, scaleConfig.Iteration)
+		if scaleConfig.GC {
+			log.Info("Restoring machinedeployments to previous state")
+			scaleDownCtx, cancelScaleDown := context.WithTimeout(ctx, scaleConfig.ScaleDownTimeout)
+			defer cancelScaleDown()
+			if err = wscale.EditCAPIMachineDeployments(scaleDownCtx, capiClient, clientSet, scaleConfig.CAPINamespace, machineDeploymentsToEdit, false); err != nil {
+				return "", err
+			}
+		}
+		wscale.UnregisterRollback(wscale.MachineDeploymentsRollback)
+		wscale.CompleteRunState(scaleConfig.GC)
+		return amiID, nil
+	}
+}
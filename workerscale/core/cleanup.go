@@ -0,0 +1,100 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/kube-burner/kube-burner/pkg/config"
+	log "github.com/sirupsen/logrus"
+	wscale "github.com/vishnuchalla/workers-scale/workerscale"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// Cleanup deletes the resources created by a previous run and restores its machinesets, nodepools and machinedeployments
+func Cleanup(ctx context.Context, clientSet kubernetes.Interface, restConfig *rest.Config, runState wscale.RunState) error {
+	var errs []error
+	dynamicClient := dynamic.NewForConfigOrDie(restConfig)
+	for _, job := range runState.CreatedResources[wscale.JobKind] {
+		errs = append(errs, DeleteBatchJob(ctx, clientSet, job))
+	}
+	if len(runState.CreatedResources[wscale.PodKind]) > 0 {
+		errs = append(errs, wscale.DeleteProbePods(ctx, clientSet))
+	}
+	if len(runState.CreatedResources[wscale.ClusterAutoscalerKind]) > 0 {
+		errs = append(errs, deleteAutoScaler(ctx, dynamicClient))
+	}
+	for _, machineSet := range runState.CreatedResources[wscale.MachineAutoscalerKind] {
+		errs = append(errs, deleteMachineAutoscaler(ctx, dynamicClient, machineSet))
+	}
+	if len(runState.NodePools) > 0 || len(runState.MachineDeployments) > 0 {
+		errs = append(errs, cleanupManagementCluster(ctx, runState))
+	}
+	if len(runState.MachineSets) == 0 && len(runState.CreatedResources[wscale.MachineSetKind]) == 0 {
+		return errors.Join(errs...)
+	}
+	machineClient, err := wscale.GetMachineClient(restConfig)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	ephemeralMachineSets := slices.Clone(runState.CreatedResources[wscale.MachineSetKind])
+	if len(runState.MachineSets) > 0 {
+		log.Info("Restoring machine sets to previous state")
+		machineSetsToEdit := runState.MachineSetsToEdit()
+		// Ephemeral machinesets are deleted along with their machines instead
+		for _, machineSet := range ephemeralMachineSets {
+			machineSetsToEdit.Delete(machineSet)
+		}
+		if err := wscale.RestoreMachineSets(ctx, machineClient, machineSetsToEdit); err != nil {
+			errs = append(errs, fmt.Errorf("error restoring machinesets: %v", err))
+		}
+	}
+	for _, machineSet := range ephemeralMachineSets {
+		errs = append(errs, wscale.DeleteEphemeralMachineSet(ctx, machineClient, machineSet))
+	}
+	return errors.Join(errs...)
+}
+
+// cleanupManagementCluster restores the HyperShift nodepools and cluster api machinedeployments of a previous run
+func cleanupManagementCluster(ctx context.Context, runState wscale.RunState) error {
+	if runState.MCKubeConfig == "" {
+		return fmt.Errorf("management cluster kubeconfig not recorded, unable to restore nodepools and machinedeployments")
+	}
+	mcKubeClientProvider := config.NewKubeClientProvider(runState.MCKubeConfig, "")
+	_, mcRestConfig := mcKubeClientProvider.ClientSet(0, 0)
+	var errs []error
+	if len(runState.NodePools) > 0 {
+		log.Info("Restoring nodepools to previous state")
+		if err := wscale.RestoreNodePools(ctx, dynamic.NewForConfigOrDie(mcRestConfig), runState.NodePools); err != nil {
+			errs = append(errs, fmt.Errorf("error restoring nodepools: %v", err))
+		}
+	}
+	if len(runState.MachineDeployments) > 0 {
+		log.Info("Restoring machinedeployments to previous state")
+		capiClient, err := wscale.GetCAPIClient(mcRestConfig)
+		if err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+		if err := wscale.RestoreCAPIMachineDeployments(ctx, capiClient, runState.CAPINamespace, runState.MachineDeploymentsToEdit()); err != nil {
+			errs = append(errs, fmt.Errorf("error restoring machinedeployments: %v", err))
+		}
+	}
+	return errors.Join(errs...)
+}
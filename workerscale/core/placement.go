@@ -0,0 +1,185 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+	wscale "github.com/vishnuchalla/workers-scale/workerscale"
+)
+
+// planPlacement distributes the additional workers across machinesets following the configured placement strategy
+func planPlacement(machineSetReplicas map[int][]string, machineSetZones map[string]string, scaleConfig wscale.ScaleConfig) (*sync.Map, error) {
+	replicas := make(map[string]int)
+	for replicaCount, machineSets := range machineSetReplicas {
+		for _, machineSet := range machineSets {
+			replicas[machineSet] = replicaCount
+		}
+	}
+	if len(replicas) == 0 {
+		return nil, fmt.Errorf("no machinesets found to scale")
+	}
+	var additions map[string]int
+	var err error
+	switch scaleConfig.PlacementStrategy {
+	case "", wscale.EvenPlacement:
+		return adjustMachineSets(machineSetReplicas, scaleConfig.AdditionalWorkerNodes), nil
+	case wscale.ZoneRoundRobinPlacement:
+		additions = roundRobinPlacement(replicas, machineSetZones, scaleConfig.AdditionalWorkerNodes)
+	case wscale.WeightedPlacement:
+		additions, err = weightedPlacement(replicas, scaleConfig.PlacementWeights, scaleConfig.AdditionalWorkerNodes)
+	case wscale.SinglePlacement:
+		additions, err = singlePlacement(replicas, scaleConfig.PlacementTarget, scaleConfig.AdditionalWorkerNodes)
+	case wscale.ProportionalPlacement:
+		// Machinesets keep their relative sizes, unless all of them are empty
+		if additions, err = weightedPlacement(replicas, replicas, scaleConfig.AdditionalWorkerNodes); err != nil {
+			log.Warnf("Unable to place workers proportionally: %v. Spreading them evenly instead", err)
+			return adjustMachineSets(machineSetReplicas, scaleConfig.AdditionalWorkerNodes), nil
+		}
+	case wscale.ExplicitPlacement:
+		additions, err = explicitPlacement(replicas, scaleConfig.PlacementCounts)
+	default:
+		return nil, fmt.Errorf("unknown placement strategy: %s", scaleConfig.PlacementStrategy)
+	}
+	if err != nil {
+		return nil, err
+	}
+	machineSetsToEdit := sync.Map{}
+	for machineSet, addition := range additions {
+		if addition > 0 {
+			machineSetsToEdit.Store(machineSet, wscale.MachineSetInfo{
+				PrevReplicas:    replicas[machineSet],
+				CurrentReplicas: replicas[machineSet] + addition,
+			})
+		}
+	}
+	return &machineSetsToEdit, nil
+}
+
+// roundRobinPlacement adds one worker per zone in turn, to the least populated machineset of the zone
+func roundRobinPlacement(replicas map[string]int, machineSetZones map[string]string, desiredWorkerCount int) map[string]int {
+	additions := make(map[string]int)
+	zoneMachineSets := make(map[string][]string)
+	for machineSet := range replicas {
+		zoneMachineSets[machineSetZones[machineSet]] = append(zoneMachineSets[machineSetZones[machineSet]], machineSet)
+	}
+	var zones []string
+	for zone := range zoneMachineSets {
+		zones = append(zones, zone)
+		sort.Strings(zoneMachineSets[zone])
+	}
+	sort.Strings(zones)
+	for index := 0; index < desiredWorkerCount; index++ {
+		var target string
+		for _, machineSet := range zoneMachineSets[zones[index%len(zones)]] {
+			if target == "" || replicas[machineSet]+additions[machineSet] < replicas[target]+additions[target] {
+				target = machineSet
+			}
+		}
+		additions[target]++
+	}
+	return additions
+}
+
+// weightedPlacement splits the workers across machinesets by their weights, handing out the remainder by largest fraction
+func weightedPlacement(replicas map[string]int, weights map[string]int, desiredWorkerCount int) (map[string]int, error) {
+	var totalWeight int
+	var machineSets []string
+	for machineSet, weight := range weights {
+		if _, exists := replicas[machineSet]; !exists {
+			return nil, fmt.Errorf("machineset %s not found", machineSet)
+		}
+		if weight < 0 {
+			return nil, fmt.Errorf("negative weight %d for machineset %s", weight, machineSet)
+		}
+		totalWeight += weight
+		machineSets = append(machineSets, machineSet)
+	}
+	if totalWeight == 0 {
+		return nil, fmt.Errorf("weights add up to 0")
+	}
+	sort.Strings(machineSets)
+	additions := make(map[string]int)
+	remainders := make(map[string]int)
+	placed := 0
+	for _, machineSet := range machineSets {
+		additions[machineSet] = desiredWorkerCount * weights[machineSet] / totalWeight
+		remainders[machineSet] = desiredWorkerCount * weights[machineSet] % totalWeight
+		placed += additions[machineSet]
+	}
+	sort.SliceStable(machineSets, func(i, j int) bool {
+		return remainders[machineSets[i]] > remainders[machineSets[j]]
+	})
+	for index := 0; placed < desiredWorkerCount; index++ {
+		additions[machineSets[index]]++
+		placed++
+	}
+	return additions, nil
+}
+
+// singlePlacement adds every worker to one machineset, the least populated one when no target is given
+func singlePlacement(replicas map[string]int, target string, desiredWorkerCount int) (map[string]int, error) {
+	if target == "" {
+		var machineSets []string
+		for machineSet := range replicas {
+			machineSets = append(machineSets, machineSet)
+		}
+		sort.Strings(machineSets)
+		for _, machineSet := range machineSets {
+			if target == "" || replicas[machineSet] < replicas[target] {
+				target = machineSet
+			}
+		}
+	}
+	if _, exists := replicas[target]; !exists {
+		return nil, fmt.Errorf("machineset %s not found", target)
+	}
+	return map[string]int{target: desiredWorkerCount}, nil
+}
+
+// explicitPlacement adds the given number of workers to each machineset
+func explicitPlacement(replicas map[string]int, counts map[string]int) (map[string]int, error) {
+	for machineSet, count := range counts {
+		if _, exists := replicas[machineSet]; !exists {
+			return nil, fmt.Errorf("machineset %s not found", machineSet)
+		}
+		if count < 0 {
+			return nil, fmt.Errorf("negative worker count %d for machineset %s", count, machineSet)
+		}
+	}
+	return counts, nil
+}
+
+// printPlacementPlan logs the replicas every machineset will be scaled to
+func printPlacementPlan(machineSetsToEdit *sync.Map, strategy string) {
+	if strategy == "" {
+		strategy = wscale.EvenPlacement
+	}
+	var machineSets []string
+	machineSetsToEdit.Range(func(key, value interface{}) bool {
+		machineSets = append(machineSets, key.(string))
+		return true
+	})
+	sort.Strings(machineSets)
+	log.Infof("Placement plan using the %s strategy:", strategy)
+	for _, machineSet := range machineSets {
+		msValue, _ := machineSetsToEdit.Load(machineSet)
+		msInfo := msValue.(wscale.MachineSetInfo)
+		log.Infof("  %s: %d -> %d replicas (+%d)", machineSet, msInfo.PrevReplicas, msInfo.CurrentReplicas, msInfo.CurrentReplicas-msInfo.PrevReplicas)
+	}
+}
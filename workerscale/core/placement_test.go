@@ -0,0 +1,267 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	wscale "github.com/vishnuchalla/workers-scale/workerscale"
+)
+
+// groupByReplicas groups the machinesets by replica count, the way they are listed from the cluster
+func groupByReplicas(replicas map[string]int) map[int][]string {
+	machineSetReplicas := make(map[int][]string)
+	var machineSets []string
+	for machineSet := range replicas {
+		machineSets = append(machineSets, machineSet)
+	}
+	sort.Strings(machineSets)
+	for _, machineSet := range machineSets {
+		machineSetReplicas[replicas[machineSet]] = append(machineSetReplicas[replicas[machineSet]], machineSet)
+	}
+	return machineSetReplicas
+}
+
+func TestPlanPlacement(t *testing.T) {
+	zones := map[string]string{"a": "us-east-1a", "b": "us-east-1a", "c": "us-east-1b"}
+	tests := []struct {
+		name        string
+		replicas    map[string]int
+		scaleConfig wscale.ScaleConfig
+		expected    map[string]wscale.MachineSetInfo
+		expectErr   bool
+	}{
+		{
+			name:        "even fills the least populated machinesets first",
+			replicas:    map[string]int{"a": 1, "b": 1, "c": 2},
+			scaleConfig: wscale.ScaleConfig{AdditionalWorkerNodes: 4},
+			expected: map[string]wscale.MachineSetInfo{
+				"a": {PrevReplicas: 1, CurrentReplicas: 3},
+				"b": {PrevReplicas: 1, CurrentReplicas: 2},
+				"c": {PrevReplicas: 2, CurrentReplicas: 3},
+			},
+		},
+		{
+			name:        "even with fewer workers than machinesets",
+			replicas:    map[string]int{"a": 0, "b": 0, "c": 0},
+			scaleConfig: wscale.ScaleConfig{PlacementStrategy: wscale.EvenPlacement, AdditionalWorkerNodes: 2},
+			expected: map[string]wscale.MachineSetInfo{
+				"a": {PrevReplicas: 0, CurrentReplicas: 1},
+				"b": {PrevReplicas: 0, CurrentReplicas: 1},
+			},
+		},
+		{
+			name:        "even splits equally across the empty compared variants",
+			replicas:    map[string]int{"a": 0, "b": 0},
+			scaleConfig: wscale.ScaleConfig{PlacementStrategy: wscale.EvenPlacement, AdditionalWorkerNodes: 6},
+			expected: map[string]wscale.MachineSetInfo{
+				"a": {PrevReplicas: 0, CurrentReplicas: 3},
+				"b": {PrevReplicas: 0, CurrentReplicas: 3},
+			},
+		},
+		{
+			name:        "round robin alternates zones",
+			replicas:    map[string]int{"a": 1, "b": 0, "c": 2},
+			scaleConfig: wscale.ScaleConfig{PlacementStrategy: wscale.ZoneRoundRobinPlacement, AdditionalWorkerNodes: 3},
+			expected: map[string]wscale.MachineSetInfo{
+				"a": {PrevReplicas: 1, CurrentReplicas: 2},
+				"b": {PrevReplicas: 0, CurrentReplicas: 1},
+				"c": {PrevReplicas: 2, CurrentReplicas: 3},
+			},
+		},
+		{
+			name:        "round robin with fewer workers than zones",
+			replicas:    map[string]int{"a": 1, "b": 0, "c": 2},
+			scaleConfig: wscale.ScaleConfig{PlacementStrategy: wscale.ZoneRoundRobinPlacement, AdditionalWorkerNodes: 1},
+			expected: map[string]wscale.MachineSetInfo{
+				"b": {PrevReplicas: 0, CurrentReplicas: 1},
+			},
+		},
+		{
+			name:     "weighted hands out the remainder by largest fraction",
+			replicas: map[string]int{"a": 1, "b": 1, "c": 1},
+			scaleConfig: wscale.ScaleConfig{
+				PlacementStrategy:     wscale.WeightedPlacement,
+				PlacementWeights:      map[string]int{"a": 2, "b": 1, "c": 1},
+				AdditionalWorkerNodes: 7,
+			},
+			expected: map[string]wscale.MachineSetInfo{
+				"a": {PrevReplicas: 1, CurrentReplicas: 4},
+				"b": {PrevReplicas: 1, CurrentReplicas: 3},
+				"c": {PrevReplicas: 1, CurrentReplicas: 3},
+			},
+		},
+		{
+			name:     "weighted breaks remainder ties by name",
+			replicas: map[string]int{"a": 0, "b": 0, "c": 0},
+			scaleConfig: wscale.ScaleConfig{
+				PlacementStrategy:     wscale.WeightedPlacement,
+				PlacementWeights:      map[string]int{"a": 1, "b": 1, "c": 1},
+				AdditionalWorkerNodes: 2,
+			},
+			expected: map[string]wscale.MachineSetInfo{
+				"a": {PrevReplicas: 0, CurrentReplicas: 1},
+				"b": {PrevReplicas: 0, CurrentReplicas: 1},
+			},
+		},
+		{
+			name:     "weighted skips zero weight and unweighted machinesets",
+			replicas: map[string]int{"a": 1, "b": 1, "c": 1},
+			scaleConfig: wscale.ScaleConfig{
+				PlacementStrategy:     wscale.WeightedPlacement,
+				PlacementWeights:      map[string]int{"a": 1, "b": 0},
+				AdditionalWorkerNodes: 3,
+			},
+			expected: map[string]wscale.MachineSetInfo{
+				"a": {PrevReplicas: 1, CurrentReplicas: 4},
+			},
+		},
+		{
+			name:     "weighted with only zero weights",
+			replicas: map[string]int{"a": 1, "b": 1},
+			scaleConfig: wscale.ScaleConfig{
+				PlacementStrategy:     wscale.WeightedPlacement,
+				PlacementWeights:      map[string]int{"a": 0, "b": 0},
+				AdditionalWorkerNodes: 3,
+			},
+			expectErr: true,
+		},
+		{
+			name:     "weighted with a negative weight",
+			replicas: map[string]int{"a": 1, "b": 1},
+			scaleConfig: wscale.ScaleConfig{
+				PlacementStrategy:     wscale.WeightedPlacement,
+				PlacementWeights:      map[string]int{"a": 2, "b": -1},
+				AdditionalWorkerNodes: 3,
+			},
+			expectErr: true,
+		},
+		{
+			name:     "weighted with an unknown machineset",
+			replicas: map[string]int{"a": 1},
+			scaleConfig: wscale.ScaleConfig{
+				PlacementStrategy:     wscale.WeightedPlacement,
+				PlacementWeights:      map[string]int{"z": 1},
+				AdditionalWorkerNodes: 3,
+			},
+			expectErr: true,
+		},
+		{
+			name:        "proportional keeps the relative sizes",
+			replicas:    map[string]int{"a": 3, "b": 1, "c": 0},
+			scaleConfig: wscale.ScaleConfig{PlacementStrategy: wscale.ProportionalPlacement, AdditionalWorkerNodes: 8},
+			expected: map[string]wscale.MachineSetInfo{
+				"a": {PrevReplicas: 3, CurrentReplicas: 9},
+				"b": {PrevReplicas: 1, CurrentReplicas: 3},
+			},
+		},
+		{
+			name:        "proportional falls back to even when every machineset is empty",
+			replicas:    map[string]int{"a": 0, "b": 0},
+			scaleConfig: wscale.ScaleConfig{PlacementStrategy: wscale.ProportionalPlacement, AdditionalWorkerNodes: 3},
+			expected: map[string]wscale.MachineSetInfo{
+				"a": {PrevReplicas: 0, CurrentReplicas: 2},
+				"b": {PrevReplicas: 0, CurrentReplicas: 1},
+			},
+		},
+		{
+			name:        "single picks the least populated machineset",
+			replicas:    map[string]int{"a": 2, "b": 1, "c": 1},
+			scaleConfig: wscale.ScaleConfig{PlacementStrategy: wscale.SinglePlacement, AdditionalWorkerNodes: 3},
+			expected: map[string]wscale.MachineSetInfo{
+				"b": {PrevReplicas: 1, CurrentReplicas: 4},
+			},
+		},
+		{
+			name:        "single with a target",
+			replicas:    map[string]int{"a": 2, "b": 1, "c": 1},
+			scaleConfig: wscale.ScaleConfig{PlacementStrategy: wscale.SinglePlacement, PlacementTarget: "a", AdditionalWorkerNodes: 3},
+			expected: map[string]wscale.MachineSetInfo{
+				"a": {PrevReplicas: 2, CurrentReplicas: 5},
+			},
+		},
+		{
+			name:        "single with an unknown target",
+			replicas:    map[string]int{"a": 2},
+			scaleConfig: wscale.ScaleConfig{PlacementStrategy: wscale.SinglePlacement, PlacementTarget: "z", AdditionalWorkerNodes: 3},
+			expectErr:   true,
+		},
+		{
+			name:     "explicit adds the given counts",
+			replicas: map[string]int{"a": 1, "b": 1, "c": 1},
+			scaleConfig: wscale.ScaleConfig{
+				PlacementStrategy: wscale.ExplicitPlacement,
+				PlacementCounts:   map[string]int{"a": 2, "c": 0},
+			},
+			expected: map[string]wscale.MachineSetInfo{
+				"a": {PrevReplicas: 1, CurrentReplicas: 3},
+			},
+		},
+		{
+			name:     "explicit with a negative count",
+			replicas: map[string]int{"a": 1},
+			scaleConfig: wscale.ScaleConfig{
+				PlacementStrategy: wscale.ExplicitPlacement,
+				PlacementCounts:   map[string]int{"a": -1},
+			},
+			expectErr: true,
+		},
+		{
+			name:     "explicit with an unknown machineset",
+			replicas: map[string]int{"a": 1},
+			scaleConfig: wscale.ScaleConfig{
+				PlacementStrategy: wscale.ExplicitPlacement,
+				PlacementCounts:   map[string]int{"z": 1},
+			},
+			expectErr: true,
+		},
+		{
+			name:        "unknown strategy",
+			replicas:    map[string]int{"a": 1},
+			scaleConfig: wscale.ScaleConfig{PlacementStrategy: "random", AdditionalWorkerNodes: 1},
+			expectErr:   true,
+		},
+		{
+			name:        "no machinesets",
+			replicas:    map[string]int{},
+			scaleConfig: wscale.ScaleConfig{AdditionalWorkerNodes: 1},
+			expectErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			machineSetsToEdit, err := planPlacement(groupByReplicas(tt.replicas), zones, tt.scaleConfig)
+			if tt.expectErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			placement := make(map[string]wscale.MachineSetInfo)
+			machineSetsToEdit.Range(func(key, value interface{}) bool {
+				placement[key.(string)] = value.(wscale.MachineSetInfo)
+				return true
+			})
+			if !reflect.DeepEqual(placement, tt.expected) {
+				t.Errorf("got %+v, expected %+v", placement, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerscale
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	certificatesv1 "k8s.io/api/certificates/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// csrStatus tracks a kubelet CSR requested while watching the cluster
+type csrStatus struct {
+	nodeName   string
+	signerName string
+	approved   bool
+	state      string
+}
+
+// csrIssues holds the CSRs left pending, denied or failed by the watchers of a run
+var csrIssues = make(map[string]string)
+var csrIssuesLock sync.Mutex
+
+// observeCSR records the creation and approval of the kubelet client and serving CSRs of a node
+func (w *ClusterWatcher) observeCSR(obj interface{}, isInInitialList bool) {
+	csr, ok := obj.(*certificatesv1.CertificateSigningRequest)
+	if !ok {
+		return
+	}
+	var createdEvent, approvedEvent string
+	switch csr.Spec.SignerName {
+	case certificatesv1.KubeAPIServerClientKubeletSignerName:
+		createdEvent, approvedEvent = nodeClientCSRCreatedEvent, nodeClientCSRApprovedEvent
+	case certificatesv1.KubeletServingSignerName:
+		createdEvent, approvedEvent = nodeServingCSRCreatedEvent, nodeServingCSRApprovedEvent
+	default:
+		return
+	}
+	nodeName := getCSRNodeName(csr)
+	if nodeName == "" {
+		return
+	}
+	w.mu.Lock()
+	recordEvent(w.nodeEvents, nodeName, createdEvent, observedTimestamp(&csr.CreationTimestamp, isInInitialList))
+	status := csrStatus{nodeName: nodeName, signerName: csr.Spec.SignerName}
+	for _, condition := range csr.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case certificatesv1.CertificateApproved:
+			status.approved = true
+			approvalTimestamp := condition.LastUpdateTime
+			if approvalTimestamp.IsZero() {
+				approvalTimestamp = condition.LastTransitionTime
+			}
+			recordEvent(w.nodeEvents, nodeName, approvedEvent, observedTimestamp(&approvalTimestamp, isInInitialList))
+		case certificatesv1.CertificateDenied, certificatesv1.CertificateFailed:
+			status.state = string(condition.Type)
+		}
+	}
+	// CSRs requested before watching belong to nodes outside of the run
+	if !isInInitialList {
+		w.csrs[csr.Name] = status
+	}
+	w.mu.Unlock()
+	w.notify()
+}
+
+// getCSRNodeName returns the node a kubelet CSR was requested for, from the system:node:<name> common name
+func getCSRNodeName(csr *certificatesv1.CertificateSigningRequest) string {
+	block, _ := pem.Decode(csr.Spec.Request)
+	if block == nil {
+		return ""
+	}
+	request, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		log.Debugf("Error parsing CSR %s: %v", csr.Name, err)
+		return ""
+	}
+	nodeName, found := strings.CutPrefix(request.Subject.CommonName, "system:node:")
+	if !found {
+		return ""
+	}
+	return nodeName
+}
+
+// CSRIssues describes the kubelet CSRs of the scaled nodes which are not approved. Pending CSRs are left out
+// once another CSR of the same signer was approved for the node, as kubelets retry with new CSRs
+func (w *ClusterWatcher) CSRIssues() map[string]string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	scaledNodes := w.scaledMachineNodes()
+	approvedSigners := make(map[string]bool)
+	for _, status := range w.csrs {
+		if status.approved {
+			approvedSigners[status.nodeName+"/"+status.signerName] = true
+		}
+	}
+	issues := make(map[string]string)
+	for name, status := range w.csrs {
+		if status.approved {
+			continue
+		}
+		// Without machines to match, nodes which existed before the watcher started are not scaled
+		if scaledNodes != nil && !scaledNodes[status.nodeName] || scaledNodes == nil && w.initialNodes[status.nodeName] {
+			continue
+		}
+		state := status.state
+		if state == "" {
+			if approvedSigners[status.nodeName+"/"+status.signerName] {
+				continue
+			}
+			state = csrPendingState
+		}
+		csrType := "client"
+		if status.signerName == certificatesv1.KubeletServingSignerName {
+			csrType = "serving"
+		}
+		issues[name] = fmt.Sprintf("%s %s CSR %s of node %s", state, csrType, name, status.nodeName)
+	}
+	return issues
+}
+
+// recordCSRIssues keeps the CSR issues found by a watcher for the run summary
+func recordCSRIssues(issues map[string]string) {
+	csrIssuesLock.Lock()
+	defer csrIssuesLock.Unlock()
+	for name, issue := range issues {
+		log.Warn(issue)
+		csrIssues[name] = issue
+	}
+}
+
+// GetCSRIssues returns the kubelet CSRs left pending, denied or failed during the run
+func GetCSRIssues() []string {
+	csrIssuesLock.Lock()
+	defer csrIssuesLock.Unlock()
+	var issues []string
+	for _, issue := range csrIssues {
+		issues = append(issues, issue)
+	}
+	sort.Strings(issues)
+	return issues
+}
+
+// joinCSRIssues formats CSR issues to be appended to an error
+func joinCSRIssues(issues map[string]string) string {
+	var issueList []string
+	for _, issue := range issues {
+		issueList = append(issueList, issue)
+	}
+	sort.Strings(issueList)
+	return strings.Join(issueList, ", ")
+}
+
+// csrPendingLatency calculates how long a CSR waited for the machine approver, zero when either step was not observed
+func csrPendingLatency(creationTimestamp time.Time, approvalTimestamp time.Time) int {
+	if creationTimestamp.IsZero() || approvalTimestamp.IsZero() {
+		return 0
+	}
+	return int(approvalTimestamp.Sub(creationTimestamp).Milliseconds())
+}
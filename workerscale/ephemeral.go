@@ -0,0 +1,273 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerscale
+
+import (
+	"context"
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	machinev1beta1 "github.com/openshift/client-go/machine/clientset/versioned/typed/machine/v1beta1"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// ownershipMetadata are the labels and annotations through which tools manage the template machineset
+var ownershipMetadata = []string{
+	"app.kubernetes.io/managed-by",
+	"hive.openshift.io/managed",
+	"kubectl.kubernetes.io/last-applied-configuration",
+}
+
+// CreateEphemeralMachineSets clones the template machineset with the requested overrides, twice when comparing variants
+func CreateEphemeralMachineSets(ctx context.Context, machineClient *machinev1beta1.MachineV1beta1Client, scaleConfig ScaleConfig) ([]string, error) {
+	ephemeralConfig := scaleConfig.EphemeralMachineSet
+	template, err := machineClient.MachineSets(MachineNamespace).Get(ctx, ephemeralConfig.Template, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error getting template machineset %s: %v", ephemeralConfig.Template, err)
+	}
+	// A short uuid prefix keeps the machine names, derived from the machineset name, within the label value limit
+	uuidPrefix := scaleConfig.UUID
+	if len(uuidPrefix) > 8 {
+		uuidPrefix = uuidPrefix[:8]
+	}
+	name := fmt.Sprintf("%s-%s-%d", ephemeralConfig.Template, uuidPrefix, scaleConfig.Iteration)
+	variants := map[string]EphemeralMachineSetConfig{name: ephemeralConfig}
+	if ephemeralConfig.IsComparison() {
+		// The candidate only differs from the baseline in the compared boot image and instance type
+		candidateConfig := ephemeralConfig
+		if ephemeralConfig.CompareBootImage != "" {
+			candidateConfig.BootImage = ephemeralConfig.CompareBootImage
+		}
+		if ephemeralConfig.CompareInstanceType != "" {
+			candidateConfig.InstanceType = ephemeralConfig.CompareInstanceType
+		}
+		variants = map[string]EphemeralMachineSetConfig{
+			name + "-" + baselineVariant:  ephemeralConfig,
+			name + "-" + candidateVariant: candidateConfig,
+		}
+	}
+	var names []string
+	RegisterRollback(EphemeralMachineSetRollback, func() error {
+		var errs []error
+		for _, name := range names {
+			errs = append(errs, DeleteEphemeralMachineSet(context.Background(), machineClient, name))
+		}
+		return goerrors.Join(errs...)
+	})
+	for variantName, variantConfig := range variants {
+		if err := createEphemeralMachineSet(ctx, machineClient, template.DeepCopy(), variantName, scaleConfig, variantConfig); err != nil {
+			return nil, err
+		}
+		names = append(names, variantName)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// createEphemeralMachineSet creates a clone of the template machineset with 0 replicas
+func createEphemeralMachineSet(ctx context.Context, machineClient *machinev1beta1.MachineV1beta1Client, template *machinev1.MachineSet, name string, scaleConfig ScaleConfig, ephemeralConfig EphemeralMachineSetConfig) error {
+	rawProviderSpec, err := overrideProviderSpec(template.Spec.Template.Spec.ProviderSpec.Value.Raw, scaleConfig.Platform, ephemeralConfig)
+	if err != nil {
+		return fmt.Errorf("error overriding providerSpec of machineset %s: %v", ephemeralConfig.Template, err)
+	}
+	// Owner references are left out, so that no controller adopts or garbage collects the clone
+	machineSet := &machinev1.MachineSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   MachineNamespace,
+			Labels:      cloneMetadata(template.Labels),
+			Annotations: cloneMetadata(template.Annotations),
+		},
+		Spec: template.Spec,
+	}
+	machineSet.Labels[ephemeralMachineSetLabel] = scaleConfig.UUID
+	machineSet.Spec.Replicas = Int32Ptr(0)
+	if machineSet.Spec.Selector.MatchLabels == nil {
+		machineSet.Spec.Selector.MatchLabels = make(map[string]string)
+	}
+	machineSet.Spec.Selector.MatchLabels["machine.openshift.io/cluster-api-machineset"] = name
+	if machineSet.Spec.Template.ObjectMeta.Labels == nil {
+		machineSet.Spec.Template.ObjectMeta.Labels = make(map[string]string)
+	}
+	machineSet.Spec.Template.ObjectMeta.Labels["machine.openshift.io/cluster-api-machineset"] = name
+	machineSet.Spec.Template.Spec.ProviderSpec.Value.Raw = rawProviderSpec
+	// Labels and taints of the machine spec are propagated to the node
+	if len(ephemeralConfig.Labels) > 0 && machineSet.Spec.Template.Spec.ObjectMeta.Labels == nil {
+		machineSet.Spec.Template.Spec.ObjectMeta.Labels = make(map[string]string)
+	}
+	for key, value := range ephemeralConfig.Labels {
+		machineSet.Spec.Template.Spec.ObjectMeta.Labels[key] = value
+	}
+	machineSet.Spec.Template.Spec.Taints = append(machineSet.Spec.Template.Spec.Taints, ephemeralConfig.Taints...)
+	if _, err = machineClient.MachineSets(MachineNamespace).Create(ctx, machineSet, metav1.CreateOptions{}); err != nil {
+		return fmt.Errorf("error creating ephemeral machineset %s: %v", name, err)
+	}
+	RecordCreatedResource(MachineSetKind, name)
+	log.Infof("Ephemeral machineset %s cloned from %s", name, ephemeralConfig.Template)
+	return nil
+}
+
+// cloneMetadata copies the template labels or annotations, leaving out the autoscaler node group bounds and the ones claiming ownership of the template
+func cloneMetadata(metadata map[string]string) map[string]string {
+	clonedMetadata := make(map[string]string)
+	for key, value := range metadata {
+		if strings.HasPrefix(key, autoscalerNodeGroupAnnotationPrefix) || slices.Contains(ownershipMetadata, key) {
+			continue
+		}
+		clonedMetadata[key] = value
+	}
+	return clonedMetadata
+}
+
+// IsComparison tells whether a candidate variant of the ephemeral machineset is compared against the baseline
+func (c EphemeralMachineSetConfig) IsComparison() bool {
+	return c.CompareBootImage != "" || c.CompareInstanceType != ""
+}
+
+// DeleteEphemeralMachineSet deletes an ephemeral machineset along with its machines
+func DeleteEphemeralMachineSet(ctx context.Context, machineClient *machinev1beta1.MachineV1beta1Client, name string) error {
+	propagationPolicy := metav1.DeletePropagationBackground
+	err := machineClient.MachineSets(MachineNamespace).Delete(ctx, name, metav1.DeleteOptions{PropagationPolicy: &propagationPolicy})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("error deleting ephemeral machineset %s: %v", name, err)
+	}
+	ForgetCreatedResource(MachineSetKind, name)
+	log.Infof("Ephemeral machineset %s deleted", name)
+	return nil
+}
+
+// overrideProviderSpec sets the instance type, boot image and zone of a raw providerSpec
+func overrideProviderSpec(raw []byte, platform string, ephemeralConfig EphemeralMachineSetConfig) ([]byte, error) {
+	providerSpec := make(map[string]interface{})
+	if err := json.Unmarshal(raw, &providerSpec); err != nil {
+		return nil, err
+	}
+	var err error
+	switch platform {
+	case AzurePlatform:
+		err = overrideAzureProviderSpec(providerSpec, ephemeralConfig)
+	case GCPPlatform:
+		err = overrideGCPProviderSpec(providerSpec, ephemeralConfig)
+	case VSpherePlatform:
+		if ephemeralConfig.InstanceType != "" || ephemeralConfig.Zone != "" {
+			return nil, fmt.Errorf("only the boot image can be overridden on %s", platform)
+		}
+		err = setNestedString(providerSpec, ephemeralConfig.BootImage, "template")
+	case BareMetalPlatform:
+		if ephemeralConfig.InstanceType != "" || ephemeralConfig.Zone != "" || ephemeralConfig.BootImage != "" {
+			return nil, fmt.Errorf("overrides are not supported on %s", platform)
+		}
+	default:
+		err = overrideAWSProviderSpec(providerSpec, ephemeralConfig)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(providerSpec)
+}
+
+// overrideAWSProviderSpec moves the subnet filters along with the availability zone
+func overrideAWSProviderSpec(providerSpec map[string]interface{}, ephemeralConfig EphemeralMachineSetConfig) error {
+	if err := setNestedString(providerSpec, ephemeralConfig.InstanceType, "instanceType"); err != nil {
+		return err
+	}
+	if err := setNestedString(providerSpec, ephemeralConfig.BootImage, "ami", "id"); err != nil {
+		return err
+	}
+	if ephemeralConfig.Zone == "" {
+		return nil
+	}
+	previousZone, _, _ := unstructured.NestedString(providerSpec, "placement", "availabilityZone")
+	if err := setNestedString(providerSpec, ephemeralConfig.Zone, "placement", "availabilityZone"); err != nil {
+		return err
+	}
+	// Installer subnets are looked up by a name carrying the zone
+	filters, _, _ := unstructured.NestedSlice(providerSpec, "subnet", "filters")
+	for _, filter := range filters {
+		filterMap, ok := filter.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		values, _ := filterMap["values"].([]interface{})
+		for i, value := range values {
+			if valueString, ok := value.(string); ok && previousZone != "" {
+				values[i] = strings.ReplaceAll(valueString, previousZone, ephemeralConfig.Zone)
+			}
+		}
+	}
+	if len(filters) > 0 {
+		return unstructured.SetNestedSlice(providerSpec, filters, "subnet", "filters")
+	}
+	log.Warnf("Subnet of the template is not looked up by filters, it may not belong to zone %s", ephemeralConfig.Zone)
+	return nil
+}
+
+// overrideAzureProviderSpec references the boot image by resource ID, or by URN for marketplace images
+func overrideAzureProviderSpec(providerSpec map[string]interface{}, ephemeralConfig EphemeralMachineSetConfig) error {
+	if err := setNestedString(providerSpec, ephemeralConfig.InstanceType, "vmSize"); err != nil {
+		return err
+	}
+	if err := setNestedString(providerSpec, ephemeralConfig.Zone, "zone"); err != nil {
+		return err
+	}
+	if ephemeralConfig.BootImage == "" {
+		return nil
+	}
+	image := map[string]interface{}{"resourceID": ephemeralConfig.BootImage}
+	if urn := strings.Split(ephemeralConfig.BootImage, ":"); len(urn) == 4 {
+		image = map[string]interface{}{"publisher": urn[0], "offer": urn[1], "sku": urn[2], "version": urn[3], "resourceID": ""}
+	}
+	return unstructured.SetNestedMap(providerSpec, image, "image")
+}
+
+// overrideGCPProviderSpec sets the image of the boot disk
+func overrideGCPProviderSpec(providerSpec map[string]interface{}, ephemeralConfig EphemeralMachineSetConfig) error {
+	if err := setNestedString(providerSpec, ephemeralConfig.InstanceType, "machineType"); err != nil {
+		return err
+	}
+	if err := setNestedString(providerSpec, ephemeralConfig.Zone, "zone"); err != nil {
+		return err
+	}
+	if ephemeralConfig.BootImage == "" {
+		return nil
+	}
+	disks, _, _ := unstructured.NestedSlice(providerSpec, "disks")
+	for _, disk := range disks {
+		diskMap, ok := disk.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if boot, _ := diskMap["boot"].(bool); boot {
+			diskMap["image"] = ephemeralConfig.BootImage
+		}
+	}
+	return unstructured.SetNestedSlice(providerSpec, disks, "disks")
+}
+
+// setNestedString sets a providerSpec field, leaving it untouched when no override is given
+func setNestedString(providerSpec map[string]interface{}, value string, fields ...string) error {
+	if value == "" {
+		return nil
+	}
+	return unstructured.SetNestedField(providerSpec, value, fields...)
+}
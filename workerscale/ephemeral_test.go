@@ -0,0 +1,59 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerscale
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCloneMetadata(t *testing.T) {
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		expected map[string]string
+	}{
+		{
+			name:     "nil metadata",
+			expected: map[string]string{},
+		},
+		{
+			name: "autoscaler node group bounds",
+			metadata: map[string]string{
+				"machine.openshift.io/cluster-api-autoscaler-node-group-min-size": "1",
+				"machine.openshift.io/cluster-api-autoscaler-node-group-max-size": "3",
+				"machine.openshift.io/memoryMb":                                   "16384",
+			},
+			expected: map[string]string{"machine.openshift.io/memoryMb": "16384"},
+		},
+		{
+			name: "ownership",
+			metadata: map[string]string{
+				"app.kubernetes.io/managed-by":                     "hive",
+				"hive.openshift.io/managed":                        "true",
+				"kubectl.kubernetes.io/last-applied-configuration": "{}",
+				"hive.openshift.io/machine-pool":                   "worker",
+			},
+			expected: map[string]string{"hive.openshift.io/machine-pool": "worker"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cloneMetadata(tt.metadata); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("got %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerscale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloud-bulldozer/go-commons/indexers"
+	"github.com/kube-burner/kube-burner/pkg/measurements"
+	mtypes "github.com/kube-burner/kube-burner/pkg/measurements/types"
+	machinev1beta1 "github.com/openshift/client-go/machine/clientset/versioned/typed/machine/v1beta1"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	capiv1beta1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// CheckFailedMachines indexes the worker machines created since the scale up which are not running, explaining them in the scale up error
+func CheckFailedMachines(ctx context.Context, machineClient *machinev1beta1.MachineV1beta1Client, clientSet kubernetes.Interface, scaleUpTimestamp time.Time, scaleConfig ScaleConfig, scaleErr error) error {
+	// The scale up may have failed because its context is done
+	diagnosticsCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+	defer cancel()
+	selected, err := selectedMachineSets(diagnosticsCtx, machineClient, scaleConfig.MachineSetFilter)
+	if err != nil {
+		return fmt.Errorf("%v, %v", scaleErr, err)
+	}
+	machines, err := machineClient.Machines(MachineNamespace).List(diagnosticsCtx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("%v, error listing machines: %v", scaleErr, err)
+	}
+	var failedMachines []interface{}
+	for _, machine := range machines.Items {
+		role := machine.Labels["machine.openshift.io/cluster-api-machine-role"]
+		if role == "" || role == "master" || role == "infra" || role == "workload" || !isMachineSelected(selected, machine.Labels) {
+			continue
+		}
+		if machine.CreationTimestamp.Time.UTC().Before(scaleUpTimestamp) {
+			continue
+		}
+		if machine.Status.Phase != nil && *machine.Status.Phase == machineRunningPhase {
+			continue
+		}
+		failedMachine := FailedMachineMetric{
+			Timestamp:         time.Now().UTC(),
+			CreationTimestamp: machine.CreationTimestamp.Time.UTC(),
+			MetricName:        failedMachinesMeasurement,
+			UUID:              scaleConfig.UUID,
+			JobName:           JobName,
+			Name:              machine.Name,
+			MachineSet:        machine.Labels["machine.openshift.io/cluster-api-machineset"],
+			Iteration:         scaleConfig.Iteration,
+			Metadata:          scaleConfig.Metadata,
+		}
+		if machine.Status.Phase != nil {
+			failedMachine.Phase = *machine.Status.Phase
+		}
+		if machine.Status.ErrorReason != nil {
+			failedMachine.ErrorReason = string(*machine.Status.ErrorReason)
+		}
+		if machine.Status.ErrorMessage != nil {
+			failedMachine.ErrorMessage = *machine.Status.ErrorMessage
+		}
+		if machine.Status.ProviderStatus != nil {
+			var providerStatus ProviderStatus
+			if err := json.Unmarshal(machine.Status.ProviderStatus.Raw, &providerStatus); err != nil {
+				log.Debugf("error unmarshaling providerStatus of machine %s: %v", machine.Name, err)
+			}
+			failedMachine.Conditions = providerStatus.Conditions
+		}
+		failedMachine.Events = getMachineEvents(diagnosticsCtx, clientSet, MachineNamespace, machine.Name)
+		failedMachines = append(failedMachines, failedMachine)
+	}
+	return indexFailedMachines(failedMachines, scaleConfig, scaleErr)
+}
+
+// CheckFailedCAPIMachines indexes the cluster api machines of the cluster created since the scale up which are not running, explaining them in the scale up error
+func CheckFailedCAPIMachines(ctx context.Context, capiClient client.Client, clientSet kubernetes.Interface, clusterID string, namespace string, scaleUpTimestamp time.Time, scaleConfig ScaleConfig, scaleErr error) error {
+	diagnosticsCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+	defer cancel()
+	selected, err := selectedMachineDeployments(diagnosticsCtx, capiClient, clusterID, namespace, scaleConfig.MachineSetFilter)
+	if err != nil {
+		return fmt.Errorf("%v, %v", scaleErr, err)
+	}
+	machines := &capiv1beta1.MachineList{}
+	if err := capiClient.List(diagnosticsCtx, machines, client.InNamespace(namespace), client.MatchingLabels{"cluster.x-k8s.io/cluster-name": clusterID}); err != nil {
+		return fmt.Errorf("%v, error listing CAPI machines: %v", scaleErr, err)
+	}
+	var failedMachines []interface{}
+	for _, machine := range machines.Items {
+		if !isCAPIObjectSelected(selected, machine.Labels) {
+			continue
+		}
+		if machine.CreationTimestamp.Time.UTC().Before(scaleUpTimestamp) || machine.Status.Phase == string(capiv1beta1.MachinePhaseRunning) {
+			continue
+		}
+		machineSet := machine.Labels[capiv1beta1.MachineDeploymentNameLabel]
+		if machineSet == "" {
+			machineSet = machine.Labels[capiv1beta1.MachineSetNameLabel]
+		}
+		failedMachine := FailedMachineMetric{
+			Timestamp:         time.Now().UTC(),
+			CreationTimestamp: machine.CreationTimestamp.Time.UTC(),
+			Phase:             machine.Status.Phase,
+			MetricName:        failedMachinesMeasurement,
+			UUID:              scaleConfig.UUID,
+			JobName:           JobName,
+			Name:              machine.Name,
+			MachineSet:        machineSet,
+			Iteration:         scaleConfig.Iteration,
+			Metadata:          scaleConfig.Metadata,
+		}
+		if machine.Status.FailureReason != nil {
+			failedMachine.ErrorReason = string(*machine.Status.FailureReason)
+		}
+		if machine.Status.FailureMessage != nil {
+			failedMachine.ErrorMessage = *machine.Status.FailureMessage
+		}
+		for _, condition := range machine.Status.Conditions {
+			failedMachine.Conditions = append(failedMachine.Conditions, ProviderStatusCondition{
+				LastTransitionTime: condition.LastTransitionTime,
+				Message:            condition.Message,
+				Reason:             condition.Reason,
+				Status:             string(condition.Status),
+				Type:               string(condition.Type),
+			})
+			// Cluster api machines report why they are stuck through their conditions rather than a failure message
+			if failedMachine.ErrorMessage == "" && condition.Status == corev1.ConditionFalse && condition.Message != "" {
+				failedMachine.ErrorMessage = fmt.Sprintf("%s: %s", condition.Type, condition.Message)
+			}
+		}
+		failedMachine.Events = getMachineEvents(diagnosticsCtx, clientSet, namespace, machine.Name)
+		failedMachines = append(failedMachines, failedMachine)
+	}
+	return indexFailedMachines(failedMachines, scaleConfig, scaleErr)
+}
+
+// indexFailedMachines indexes the machines not running, explaining them in the scale up error
+func indexFailedMachines(failedMachines []interface{}, scaleConfig ScaleConfig, scaleErr error) error {
+	if len(failedMachines) == 0 {
+		return scaleErr
+	}
+	var reasons []string
+	for _, failedMachine := range failedMachines {
+		machine := failedMachine.(FailedMachineMetric)
+		reason := fmt.Sprintf("%s in phase %q", machine.Name, machine.Phase)
+		if machine.ErrorMessage != "" {
+			reason += ": " + machine.ErrorMessage
+		}
+		reasons = append(reasons, reason)
+	}
+	log.Errorf("Machines not running: %s", strings.Join(reasons, ", "))
+	metricMap := map[string][]interface{}{
+		failedMachinesMeasurement: failedMachines,
+	}
+	measurements.IndexLatencyMeasurement(mtypes.Measurement{Name: measurementName}, JobName, metricMap, map[string]indexers.Indexer{
+		"": scaleConfig.Indexer,
+	})
+	return fmt.Errorf("%v, %d machines not running: %s", scaleErr, len(failedMachines), strings.Join(reasons, ", "))
+}
+
+// getMachineEvents returns the events related to a machine
+func getMachineEvents(ctx context.Context, clientSet kubernetes.Interface, namespace string, machineName string) []string {
+	var machineEvents []string
+	events, err := clientSet.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("involvedObject.kind=Machine,involvedObject.name=%s", machineName),
+	})
+	if err != nil {
+		log.Debugf("error listing events of machine %s: %s", machineName, err)
+		return machineEvents
+	}
+	for _, event := range events.Items {
+		machineEvents = append(machineEvents, fmt.Sprintf("%s %s: %s", event.Type, event.Reason, event.Message))
+	}
+	return machineEvents
+}
@@ -17,110 +17,261 @@ package workerscale
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	machinev1 "github.com/openshift/api/machine/v1beta1"
 	machinev1beta1 "github.com/openshift/client-go/machine/clientset/versioned/typed/machine/v1beta1"
 	log "github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/labels"
-	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
-	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
+	"k8s.io/client-go/tools/cache"
 	capiv1beta1 "sigs.k8s.io/cluster-api/api/v1beta1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// NodePoolGVR is the GroupVersionResource of hypershift nodepools
+var NodePoolGVR = schema.GroupVersionResource{
+	Group:    "hypershift.openshift.io",
+	Version:  "v1beta1",
+	Resource: "nodepools",
+}
+
 // GetMachines lists all worker machines in the cluster
-func GetMachines(machineClient *machinev1beta1.MachineV1beta1Client, scaleEventEpoch int64) (map[string]MachineInfo, string) {
-	var amiID string
+func GetMachines(ctx context.Context, machineClient *machinev1beta1.MachineV1beta1Client, scaleEventEpoch int64, platform string, filter *MachineSetFilter) (map[string]MachineInfo, string, error) {
 	var machineReadyTimestamp time.Time
 	machineDetails := make(map[string]MachineInfo)
-	machines, err := machineClient.Machines(MachineNamespace).List(context.TODO(), metav1.ListOptions{})
+	bootImageResolver := NewBootImageResolver(platform)
+	selected, err := selectedMachineSets(ctx, machineClient, filter)
+	if err != nil {
+		return nil, "", err
+	}
+	machines, err := machineClient.Machines(MachineNamespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
-		log.Fatalf("error listing machines: %s", err)
+		return nil, "", fmt.Errorf("error listing machines: %s", err)
 	}
 
 	for _, machine := range machines.Items {
+		if !isMachineSelected(selected, machine.Labels) {
+			continue
+		}
 		if _, ok := machine.Labels["machine.openshift.io/cluster-api-machine-role"]; ok &&
 			machine.Labels["machine.openshift.io/cluster-api-machine-role"] != "master" &&
 			machine.Labels["machine.openshift.io/cluster-api-machine-role"] != "infra" &&
 			machine.Labels["machine.openshift.io/cluster-api-machine-role"] != "workload" {
-			if machine.Status.Phase != nil && *machine.Status.Phase == "Running" && machine.CreationTimestamp.Time.UTC().Unix() > scaleEventEpoch {
-				if amiID == "" {
-					var awsSpec AWSProviderSpec
-					if err := json.Unmarshal(machine.Spec.ProviderSpec.Value.Raw, &awsSpec); err != nil {
-						log.Fatalf("error unmarshaling providerSpec: %v", err)
-					}
-					amiID = awsSpec.AMI.ID
+			if machine.Status.Phase != nil && *machine.Status.Phase == "Running" && machine.Status.NodeRef != nil && machine.CreationTimestamp.Time.UTC().Unix() > scaleEventEpoch {
+				bootImageID, err := bootImageResolver.ResolveBootImage(machine.Spec.ProviderSpec.Value.Raw)
+				if err != nil {
+					return nil, "", fmt.Errorf("error unmarshaling providerSpec: %v", err)
 				}
-				rawProviderStatus := machine.Status.ProviderStatus.Raw
-				var providerStatus ProviderStatus
-				if err := json.Unmarshal(rawProviderStatus, &providerStatus); err != nil {
-					log.Fatalf("error unmarshaling providerStatus: %v", err)
-				}
-				for _, condition := range providerStatus.Conditions {
-					if condition.Type == "MachineCreation" && condition.Status == "True" {
-						machineReadyTimestamp = condition.LastTransitionTime.Time.UTC()
-						break
+				machineReadyTimestamp = time.Time{}
+				// Some providers, bare metal among them, don't report a providerStatus
+				if machine.Status.ProviderStatus != nil && len(machine.Status.ProviderStatus.Raw) > 0 {
+					var providerStatus ProviderStatus
+					if err := json.Unmarshal(machine.Status.ProviderStatus.Raw, &providerStatus); err != nil {
+						return nil, "", fmt.Errorf("error unmarshaling providerStatus: %v", err)
+					}
+					for _, condition := range providerStatus.Conditions {
+						if condition.Type == getMachineReadyCondition(platform) && condition.Status == "True" {
+							machineReadyTimestamp = condition.LastTransitionTime.Time.UTC()
+							break
+						}
 					}
 				}
-				machineDetails[machine.Name] = MachineInfo{
+				if machineReadyTimestamp.IsZero() {
+					machineReadyTimestamp = machinePhaseReadyTimestamp(machine)
+				}
+				machineInfo := MachineInfo{
 					nodeUID:           string(machine.Status.NodeRef.UID),
+					nodeName:          machine.Status.NodeRef.Name,
+					machineSet:        machine.Labels["machine.openshift.io/cluster-api-machineset"],
+					bootImageID:       bootImageID,
 					creationTimestamp: machine.CreationTimestamp.Time.UTC(),
 					readyTimestamp:    machineReadyTimestamp,
 				}
+				switch platform {
+				case BareMetalPlatform:
+					machineInfo.hostRef = machine.Annotations[bareMetalHostAnnotation]
+				}
+				machineDetails[machine.Name] = machineInfo
 			}
 		}
 	}
+	amiID := joinBootImages(machineDetails)
 	log.Debugf("Machines: %v with amiID: %v", machineDetails, amiID)
-	return machineDetails, amiID
+	return machineDetails, amiID, nil
+}
+
+// joinBootImages returns the distinct boot images of the machines
+func joinBootImages(machineDetails map[string]MachineInfo) string {
+	var bootImages []string
+	for _, info := range machineDetails {
+		if info.bootImageID != "" && !slices.Contains(bootImages, info.bootImageID) {
+			bootImages = append(bootImages, info.bootImageID)
+		}
+	}
+	sort.Strings(bootImages)
+	return strings.Join(bootImages, ",")
+}
+
+// getMachineReadyCondition returns the providerStatus condition that marks a machine as created
+func getMachineReadyCondition(platform string) string {
+	switch platform {
+	case AzurePlatform, GCPPlatform:
+		return machineCreatedCondition
+	default:
+		return awsMachineReadyCondition
+	}
+}
+
+// machinePhaseReadyTimestamp tells when the instance of a machine without providerStatus conditions came up, from its InstanceExists condition
+func machinePhaseReadyTimestamp(machine machinev1.Machine) time.Time {
+	for _, condition := range machine.Status.Conditions {
+		if condition.Type == instanceExistsCondition && condition.Status == corev1.ConditionTrue {
+			return condition.LastTransitionTime.Time.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+// GetBareMetalHostPhases fills in the inspection and provisioning timestamps from the BareMetalHosts backing the machines
+func GetBareMetalHostPhases(ctx context.Context, dynamicClient dynamic.Interface, machineDetails map[string]MachineInfo) error {
+	bareMetalHostGVR := schema.GroupVersionResource{
+		Group:    "metal3.io",
+		Version:  "v1alpha1",
+		Resource: "baremetalhosts",
+	}
+	for machine, info := range machineDetails {
+		hostNamespace, hostName, found := strings.Cut(info.hostRef, "/")
+		if !found {
+			log.Debugf("Machine %s has no BareMetalHost reference", machine)
+			continue
+		}
+		host, err := dynamicClient.Resource(bareMetalHostGVR).Namespace(hostNamespace).Get(ctx, hostName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("error getting BareMetalHost %s: %v", info.hostRef, err)
+		}
+		// Hosts inspected before the machine was created did not spend any of the scale time inspecting
+		if inspectionEnd := getOperationEnd(host, "inspect"); inspectionEnd.After(info.creationTimestamp) {
+			info.inspectionTimestamp = inspectionEnd
+		}
+		if provisionEnd := getOperationEnd(host, "provision"); provisionEnd.After(info.creationTimestamp) {
+			info.provisioningTimestamp = provisionEnd
+			if info.readyTimestamp.IsZero() {
+				info.readyTimestamp = provisionEnd
+			}
+		}
+		machineDetails[machine] = info
+	}
+	return nil
+}
+
+// getOperationEnd returns the end time of an operation in the BareMetalHost operation history
+func getOperationEnd(host *unstructured.Unstructured, operation string) time.Time {
+	end, found, err := unstructured.NestedString(host.Object, "status", "operationHistory", operation, "end")
+	if err != nil || !found {
+		return time.Time{}
+	}
+	endTimestamp, err := time.Parse(time.RFC3339, end)
+	if err != nil {
+		return time.Time{}
+	}
+	return endTimestamp.UTC()
 }
 
 // GetCapiMachines to fetch cluster api kind machines
-func GetCapiMachines(capiClient client.Client, scaleEventEpoch int64, clusterID string, namespace string) (map[string]MachineInfo, string) {
-	var amiID string
+func GetCapiMachines(ctx context.Context, capiClient client.Client, scaleEventEpoch int64, clusterID string, namespace string, filter *MachineSetFilter) (map[string]MachineInfo, string, error) {
 	var machineReadyTimestamp time.Time
 	machineDetails := make(map[string]MachineInfo)
+	templateBootImages := make(map[string]string)
+	selected, err := selectedMachineDeployments(ctx, capiClient, clusterID, namespace, filter)
+	if err != nil {
+		return nil, "", err
+	}
 
 	labelSelector := client.MatchingLabels{"cluster.x-k8s.io/cluster-name": clusterID}
 	machines := &capiv1beta1.MachineList{}
-	amiID, err := getAMIIDFromAWSMachineTemplates(capiClient, namespace)
-	if err != nil {
-		log.Fatalf("error getting AMI ID from AWSMachineTemplates: %v", err)
-	}
-	if err := capiClient.List(context.TODO(), machines, client.InNamespace(namespace), labelSelector); err != nil {
-		log.Fatalf("Failed to list CAPI machines: %v", err)
+	if err := capiClient.List(ctx, machines, client.InNamespace(namespace), labelSelector); err != nil {
+		return nil, "", fmt.Errorf("failed to list CAPI machines: %v", err)
 	}
 	for _, machine := range machines.Items {
+		if !isCAPIObjectSelected(selected, machine.Labels) {
+			continue
+		}
 		if machine.Status.Phase == "Running" && machine.CreationTimestamp.Time.UTC().Unix() > scaleEventEpoch {
+			bootImageID, err := getCapiMachineBootImage(ctx, capiClient, machine, templateBootImages)
+			if err != nil {
+				return nil, "", fmt.Errorf("error getting boot image of machine %s: %v", machine.Name, err)
+			}
 			machineReadyTimestamp = getCapiMachineReadyTimestamp(machine)
+			machineSet := machine.Labels[capiv1beta1.MachineDeploymentNameLabel]
+			if machineSet == "" {
+				machineSet = machine.Labels[capiv1beta1.MachineSetNameLabel]
+			}
 			machineDetails[machine.Name] = MachineInfo{
 				nodeUID:           string(machine.Status.NodeRef.UID),
+				nodeName:          machine.Status.NodeRef.Name,
+				machineSet:        machineSet,
+				bootImageID:       bootImageID,
 				creationTimestamp: machine.CreationTimestamp.Time.UTC(),
 				readyTimestamp:    machineReadyTimestamp,
 			}
 		}
 	}
+	amiID := joinBootImages(machineDetails)
 	log.Debugf("Machines: %v with amiID: %v", machineDetails, amiID)
-	return machineDetails, amiID
+	return machineDetails, amiID, nil
 }
 
-// getAMIIDFromAWSMachineTemplates lists AWSMachineTemplates and extracts the AMI ID (only for ROSA HCP)
-func getAMIIDFromAWSMachineTemplates(capiClient client.Client, namespace string) (string, error) {
-	awsMachineTemplateList := &infrav1.AWSMachineTemplateList{}
-	if err := capiClient.List(context.TODO(), awsMachineTemplateList, &client.ListOptions{Namespace: namespace}); err != nil {
-		return "", fmt.Errorf("error listing AWSMachineTemplates: %v", err)
+// getCapiMachineBootImage resolves the boot image from the infrastructure template the machine was cloned from
+func getCapiMachineBootImage(ctx context.Context, capiClient client.Client, machine capiv1beta1.Machine, templateBootImages map[string]string) (string, error) {
+	infraRef := machine.Spec.InfrastructureRef
+	infraMachine := &unstructured.Unstructured{}
+	infraMachine.SetAPIVersion(infraRef.APIVersion)
+	infraMachine.SetKind(infraRef.Kind)
+	if err := capiClient.Get(ctx, client.ObjectKey{Namespace: machine.Namespace, Name: infraRef.Name}, infraMachine); err != nil {
+		return "", fmt.Errorf("error getting %s %s: %v", infraRef.Kind, infraRef.Name, err)
 	}
-
-	for _, template := range awsMachineTemplateList.Items {
-		amiID := template.Spec.Template.Spec.AMI.ID
-		return *amiID, nil // Return the first found AMI ID (or you can modify this logic)
+	templateName := infraMachine.GetAnnotations()[capiv1beta1.TemplateClonedFromNameAnnotation]
+	templateGroupKind := schema.ParseGroupKind(infraMachine.GetAnnotations()[capiv1beta1.TemplateClonedFromGroupKindAnnotation])
+	if templateName == "" {
+		log.Warnf("Unable to resolve the boot image of machine %s, %s %s was not cloned from a template", machine.Name, infraRef.Kind, infraRef.Name)
+		return "", nil
 	}
-
-	return "", fmt.Errorf("no AWSMachineTemplates found in namespace %s", namespace)
+	// Templates are shared by all the machines of a machineset, hence resolved only once
+	if bootImageID, exists := templateBootImages[templateGroupKind.Kind+"/"+templateName]; exists {
+		return bootImageID, nil
+	}
+	bootImageResolver := NewCAPIBootImageResolver(templateGroupKind.Kind)
+	template := &unstructured.Unstructured{}
+	template.SetGroupVersionKind(templateGroupKind.WithVersion(infraMachine.GroupVersionKind().Version))
+	if err := capiClient.Get(ctx, client.ObjectKey{Namespace: machine.Namespace, Name: templateName}, template); err != nil {
+		return "", fmt.Errorf("error getting %s %s: %v", templateGroupKind.Kind, templateName, err)
+	}
+	rawTemplate, err := template.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	bootImageID, err := bootImageResolver.ResolveBootImage(rawTemplate)
+	if err != nil {
+		return "", err
+	}
+	templateBootImages[templateGroupKind.Kind+"/"+templateName] = bootImageID
+	return bootImageID, nil
 }
 
 // Helper function to get the machine ready timestamp
@@ -134,8 +285,10 @@ func getCapiMachineReadyTimestamp(machine capiv1beta1.Machine) time.Time {
 }
 
 // EditMachineSets edits machinesets parallelly
-func EditMachineSets(machineClient *machinev1beta1.MachineV1beta1Client, clientSet kubernetes.Interface, machineSetsToEdit *sync.Map, isScaleUp bool) {
+func EditMachineSets(ctx context.Context, machineClient *machinev1beta1.MachineV1beta1Client, clientSet kubernetes.Interface, machineSetsToEdit *sync.Map, isScaleUp bool) error {
 	var wg sync.WaitGroup
+	var errsLock sync.Mutex
+	var errs []error
 	machineSetsToEdit.Range(func(key, value interface{}) bool {
 		machineSet := key.(string)
 		msInfo := value.(MachineSetInfo)
@@ -148,30 +301,36 @@ func EditMachineSets(machineClient *machinev1beta1.MachineV1beta1Client, clientS
 		wg.Add(1)
 		go func(ms string, r int) {
 			defer wg.Done()
-			err := updateMachineSetReplicas(machineClient, ms, int32(r), machineSetsToEdit)
+			err := updateMachineSetReplicas(ctx, machineClient, ms, int32(r), machineSetsToEdit)
 			if err != nil {
-				log.Fatalf("Failed to edit MachineSet %s: %v", ms, err)
+				errsLock.Lock()
+				errs = append(errs, fmt.Errorf("failed to edit MachineSet %s: %v", ms, err))
+				errsLock.Unlock()
 			}
 		}(machineSet, replica)
 		return true
 	})
 	wg.Wait()
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
 	log.Infof("All the machinesets have been editted")
-	if err := WaitForNodes(clientSet); err != nil {
-		log.Infof("Error waiting for nodes: %v", err)
+	if err := WaitForNodes(ctx, clientSet); err != nil {
+		return fmt.Errorf("error waiting for nodes: %v", err)
 	}
+	return nil
 }
 
 // updateMachineSetsReplicas updates machines replicas
-func updateMachineSetReplicas(machineClient *machinev1beta1.MachineV1beta1Client, name string, newReplicaCount int32, machineSetsToEdit *sync.Map) error {
-	machineSet, err := machineClient.MachineSets(MachineNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+func updateMachineSetReplicas(ctx context.Context, machineClient *machinev1beta1.MachineV1beta1Client, name string, newReplicaCount int32, machineSetsToEdit *sync.Map) error {
+	machineSet, err := machineClient.MachineSets(MachineNamespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
 		return fmt.Errorf("error getting machineset: %s", err)
 	}
 
 	machineSet.Spec.Replicas = &newReplicaCount
 	updateTimestamp := time.Now().UTC().Truncate(time.Second)
-	_, err = machineClient.MachineSets(MachineNamespace).Update(context.TODO(), machineSet, metav1.UpdateOptions{})
+	_, err = machineClient.MachineSets(MachineNamespace).Update(ctx, machineSet, metav1.UpdateOptions{})
 	if err != nil {
 		return fmt.Errorf("error updating machineset: %s", err)
 	}
@@ -180,7 +339,7 @@ func updateMachineSetReplicas(machineClient *machinev1beta1.MachineV1beta1Client
 	msInfo.LastUpdatedTime = updateTimestamp
 	machineSetsToEdit.Store(name, msInfo)
 
-	err = WaitForMachineSet(machineClient, name, newReplicaCount)
+	err = WaitForMachineSet(ctx, machineClient, name, newReplicaCount)
 	if err != nil {
 		return fmt.Errorf("timeout waiting for MachineSet %s to be ready: %v", name, err)
 	}
@@ -189,84 +348,382 @@ func updateMachineSetReplicas(machineClient *machinev1beta1.MachineV1beta1Client
 	return nil
 }
 
+// RestoreMachineSets sets the machinesets back to their previous replica count without waiting for them
+func RestoreMachineSets(ctx context.Context, machineClient *machinev1beta1.MachineV1beta1Client, machineSetsToEdit *sync.Map) error {
+	var errs []error
+	machineSetsToEdit.Range(func(key, value interface{}) bool {
+		machineSet := key.(string)
+		msInfo := value.(MachineSetInfo)
+		ms, err := machineClient.MachineSets(MachineNamespace).Get(ctx, machineSet, metav1.GetOptions{})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error getting machineset %s: %v", machineSet, err))
+			return true
+		}
+		ms.Spec.Replicas = Int32Ptr(int32(msInfo.PrevReplicas))
+		if _, err = machineClient.MachineSets(MachineNamespace).Update(ctx, ms, metav1.UpdateOptions{}); err != nil {
+			errs = append(errs, fmt.Errorf("error updating machineset %s: %v", machineSet, err))
+			return true
+		}
+		log.Infof("MachineSet %s restored to %d replicas", machineSet, msInfo.PrevReplicas)
+		return true
+	})
+	return errors.Join(errs...)
+}
+
+// RestoreCAPIMachineDeployments sets the machinedeployments back to their previous replica count without waiting for them
+func RestoreCAPIMachineDeployments(ctx context.Context, capiClient client.Client, namespace string, machineDeploymentsToEdit *sync.Map) error {
+	var errs []error
+	machineDeploymentsToEdit.Range(func(key, value interface{}) bool {
+		machineDeployment := key.(string)
+		mdInfo := value.(MachineSetInfo)
+		md := &capiv1beta1.MachineDeployment{}
+		if err := capiClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: machineDeployment}, md); err != nil {
+			errs = append(errs, fmt.Errorf("error getting machinedeployment %s: %v", machineDeployment, err))
+			return true
+		}
+		md.Spec.Replicas = Int32Ptr(int32(mdInfo.PrevReplicas))
+		if err := capiClient.Update(ctx, md); err != nil {
+			errs = append(errs, fmt.Errorf("error updating machinedeployment %s: %v", machineDeployment, err))
+			return true
+		}
+		log.Infof("MachineDeployment %s restored to %d replicas", machineDeployment, mdInfo.PrevReplicas)
+		return true
+	})
+	return errors.Join(errs...)
+}
+
+// EditCAPIMachineDeployments edits cluster api machinedeployments parallelly
+func EditCAPIMachineDeployments(ctx context.Context, capiClient client.WithWatch, clientSet kubernetes.Interface, namespace string, machineDeploymentsToEdit *sync.Map, isScaleUp bool) error {
+	var wg sync.WaitGroup
+	var errsLock sync.Mutex
+	var errs []error
+	machineDeploymentsToEdit.Range(func(key, value interface{}) bool {
+		machineDeployment := key.(string)
+		mdInfo := value.(MachineSetInfo)
+		var replica int
+		if isScaleUp {
+			replica = mdInfo.CurrentReplicas
+		} else {
+			replica = mdInfo.PrevReplicas
+		}
+		wg.Add(1)
+		go func(md string, r int) {
+			defer wg.Done()
+			err := updateCAPIMachineDeploymentReplicas(ctx, capiClient, namespace, md, int32(r), machineDeploymentsToEdit)
+			if err != nil {
+				errsLock.Lock()
+				errs = append(errs, fmt.Errorf("failed to edit MachineDeployment %s: %v", md, err))
+				errsLock.Unlock()
+			}
+		}(machineDeployment, replica)
+		return true
+	})
+	wg.Wait()
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	log.Infof("All the machinedeployments have been editted")
+	if err := WaitForNodes(ctx, clientSet); err != nil {
+		return fmt.Errorf("error waiting for nodes: %v", err)
+	}
+	return nil
+}
+
+// updateCAPIMachineDeploymentReplicas updates machinedeployment replicas
+func updateCAPIMachineDeploymentReplicas(ctx context.Context, capiClient client.WithWatch, namespace string, name string, newReplicaCount int32, machineDeploymentsToEdit *sync.Map) error {
+	machineDeployment := &capiv1beta1.MachineDeployment{}
+	if err := capiClient.Get(ctx, client.ObjectKey{Namespace: namespace, Name: name}, machineDeployment); err != nil {
+		return fmt.Errorf("error getting machinedeployment: %s", err)
+	}
+
+	machineDeployment.Spec.Replicas = &newReplicaCount
+	updateTimestamp := time.Now().UTC().Truncate(time.Second)
+	if err := capiClient.Update(ctx, machineDeployment); err != nil {
+		return fmt.Errorf("error updating machinedeployment: %s", err)
+	}
+	mdValue, _ := machineDeploymentsToEdit.Load(name)
+	mdInfo := mdValue.(MachineSetInfo)
+	mdInfo.LastUpdatedTime = updateTimestamp
+	machineDeploymentsToEdit.Store(name, mdInfo)
+
+	err := WaitForCAPIMachineDeployment(ctx, capiClient, namespace, name, newReplicaCount)
+	if err != nil {
+		return fmt.Errorf("timeout waiting for MachineDeployment %s to be ready: %v", name, err)
+	}
+
+	log.Infof("MachineDeployment %s updated to %d replicas", name, newReplicaCount)
+	return nil
+}
+
+// GetCAPIMachineDeployments lists all machinedeployments of a cluster
+func GetCAPIMachineDeployments(ctx context.Context, capiClient client.Client, clusterID string, namespace string, filter *MachineSetFilter) (map[int][]string, error) {
+	machineDeploymentReplicas := make(map[int][]string)
+	machineDeploymentList := &capiv1beta1.MachineDeploymentList{}
+	labelSelector := client.MatchingLabels{capiv1beta1.ClusterNameLabel: clusterID}
+	if err := capiClient.List(ctx, machineDeploymentList, client.InNamespace(namespace), labelSelector); err != nil {
+		return nil, fmt.Errorf("error listing machinedeployments: %s", err)
+	}
+
+	for _, md := range machineDeploymentList.Items {
+		if !filter.Matches(md.Name, md.Labels) {
+			continue
+		}
+		replicas := int(*md.Spec.Replicas)
+		machineDeploymentReplicas[replicas] = append(machineDeploymentReplicas[replicas], md.Name)
+	}
+	log.Debugf("MachineDeployments with replica count: %v", machineDeploymentReplicas)
+	return machineDeploymentReplicas, nil
+}
+
+// GetCAPIMachineDeploymentZones maps each machinedeployment of a cluster to its failure domain
+func GetCAPIMachineDeploymentZones(ctx context.Context, capiClient client.Client, clusterID string, namespace string, filter *MachineSetFilter) (map[string]string, error) {
+	machineDeploymentZones := make(map[string]string)
+	machineDeploymentList := &capiv1beta1.MachineDeploymentList{}
+	labelSelector := client.MatchingLabels{capiv1beta1.ClusterNameLabel: clusterID}
+	if err := capiClient.List(ctx, machineDeploymentList, client.InNamespace(namespace), labelSelector); err != nil {
+		return nil, fmt.Errorf("error listing machinedeployments: %s", err)
+	}
+
+	for _, md := range machineDeploymentList.Items {
+		if !filter.Matches(md.Name, md.Labels) {
+			continue
+		}
+		if md.Spec.Template.Spec.FailureDomain != nil {
+			machineDeploymentZones[md.Name] = *md.Spec.Template.Spec.FailureDomain
+		} else {
+			machineDeploymentZones[md.Name] = ""
+		}
+	}
+	log.Debugf("MachineDeployments with failure domains: %v", machineDeploymentZones)
+	return machineDeploymentZones, nil
+}
+
 // GetMachinesets lists all machinesets
-func GetMachinesets(machineClient *machinev1beta1.MachineV1beta1Client) map[int][]string {
+func GetMachinesets(ctx context.Context, machineClient *machinev1beta1.MachineV1beta1Client, filter *MachineSetFilter) (map[int][]string, error) {
 	machineSetReplicas := make(map[int][]string)
-	machineSets, err := machineClient.MachineSets(MachineNamespace).List(context.TODO(), metav1.ListOptions{})
+	machineSets, err := machineClient.MachineSets(MachineNamespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
-		log.Fatalf("error listing machinesets: %s", err)
+		return nil, fmt.Errorf("error listing machinesets: %s", err)
 	}
 
 	for _, ms := range machineSets.Items {
 		if ms.Labels["machine.openshift.io/cluster-api-machine-role"] != "infra" &&
-			ms.Labels["machine.openshift.io/cluster-api-machine-role"] != "workload" {
+			ms.Labels["machine.openshift.io/cluster-api-machine-role"] != "workload" &&
+			filter.Matches(ms.Name, ms.Labels) {
 			replicas := int(*ms.Spec.Replicas)
 			machineSetReplicas[replicas] = append(machineSetReplicas[replicas], ms.Name)
 		}
 	}
 	log.Debugf("MachineSets with replica count: %v", machineSetReplicas)
-	return machineSetReplicas
+	return machineSetReplicas, nil
+}
+
+// GetMachineSetZones maps each worker machineset to the zone it provisions machines in
+func GetMachineSetZones(ctx context.Context, machineClient *machinev1beta1.MachineV1beta1Client, filter *MachineSetFilter) (map[string]string, error) {
+	machineSetZones := make(map[string]string)
+	machineSets, err := machineClient.MachineSets(MachineNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing machinesets: %s", err)
+	}
+
+	for _, ms := range machineSets.Items {
+		if ms.Labels["machine.openshift.io/cluster-api-machine-role"] != "infra" &&
+			ms.Labels["machine.openshift.io/cluster-api-machine-role"] != "workload" &&
+			filter.Matches(ms.Name, ms.Labels) {
+			var zoneSpec ProviderSpecZone
+			if err := json.Unmarshal(ms.Spec.Template.Spec.ProviderSpec.Value.Raw, &zoneSpec); err != nil {
+				return nil, fmt.Errorf("error unmarshaling providerSpec: %v", err)
+			}
+			// AWS places machines in an availability zone, azure and gcp in a zone
+			machineSetZones[ms.Name] = zoneSpec.Placement.AvailabilityZone
+			if zoneSpec.Zone != "" {
+				machineSetZones[ms.Name] = zoneSpec.Zone
+			}
+		}
+	}
+	log.Debugf("MachineSets with zones: %v", machineSetZones)
+	return machineSetZones, nil
 }
 
 // WaitForMachineSet waits for machinesets to be ready with new replica count
-func WaitForMachineSet(machineClient *machinev1beta1.MachineV1beta1Client, name string, newReplicaCount int32) error {
-	return wait.PollUntilContextTimeout(context.TODO(), time.Second, maxWaitTimeout, true, func(ctx context.Context) (done bool, err error) {
-		ms, err := machineClient.MachineSets(MachineNamespace).Get(context.TODO(), name, metav1.GetOptions{})
-		if err != nil {
-			return false, err
+func WaitForMachineSet(ctx context.Context, machineClient *machinev1beta1.MachineV1beta1Client, name string, newReplicaCount int32) error {
+	watcher, release, err := acquireWatcher(ctx, machineClient, nil)
+	if err != nil {
+		return err
+	}
+	defer release()
+	var failedMachine string
+	err = watcher.WaitFor(ctx, func() bool {
+		// A failed machine is never replaced, hence the machineset would never be ready
+		if failedMachine = watcher.failedMachine(name); failedMachine != "" {
+			return true
 		}
-		if ms.Status.Replicas == ms.Status.ReadyReplicas && ms.Status.ReadyReplicas == newReplicaCount {
-			return true, nil
+		for _, ms := range watcher.MachineSets() {
+			if ms.Name != name {
+				continue
+			}
+			if ms.Status.Replicas == ms.Status.ReadyReplicas && ms.Status.ReadyReplicas == newReplicaCount {
+				return true
+			}
+			log.Debugf("Waiting for MachineSet %s to reach %d replicas, currently %d ready", name, newReplicaCount, ms.Status.ReadyReplicas)
+			return false
 		}
-		log.Debugf("Waiting for MachineSet %s to reach %d replicas, currently %d ready", name, newReplicaCount, ms.Status.ReadyReplicas)
-		return false, nil
+		log.Debugf("Waiting for MachineSet %s to exist", name)
+		return false
 	})
+	if err != nil {
+		return err
+	}
+	if failedMachine != "" {
+		return fmt.Errorf("machine %s of MachineSet %s failed", failedMachine, name)
+	}
+	return nil
 }
 
 // WaitForWorkerMachineSets waits for all the worker machinesets in specific to be ready
-func WaitForWorkerMachineSets(machineClient *machinev1beta1.MachineV1beta1Client) error {
-	return wait.PollUntilContextTimeout(context.TODO(), time.Second, maxWaitTimeout, true, func(_ context.Context) (done bool, err error) {
-		// Get all MachineSets with the worker label
-		labelSelector := metav1.ListOptions{
-			LabelSelector: "hive.openshift.io/machine-pool=worker",
-		}
-		machineSets, err := machineClient.MachineSets(MachineNamespace).List(context.TODO(), labelSelector)
-		if err != nil {
-			return false, err
-		}
-		for _, ms := range machineSets.Items {
+func WaitForWorkerMachineSets(ctx context.Context, machineClient *machinev1beta1.MachineV1beta1Client, filter *MachineSetFilter) error {
+	watcher, release, err := acquireWatcher(ctx, machineClient, nil)
+	if err != nil {
+		return err
+	}
+	defer release()
+	err = watcher.WaitFor(ctx, func() bool {
+		for _, ms := range watcher.MachineSets() {
+			// Only the selected MachineSets, or the ones with the worker label when no selection is given
+			if filter != nil && !filter.Matches(ms.Name, ms.Labels) {
+				continue
+			}
+			if filter == nil && ms.Labels["hive.openshift.io/machine-pool"] != "worker" {
+				continue
+			}
 			if ms.Status.Replicas != ms.Status.ReadyReplicas {
 				log.Debugf("Waiting for MachineSet %s to reach %d replicas, currently %d ready", ms.Name, ms.Status.Replicas, ms.Status.ReadyReplicas)
-				return false, nil
+				return false
 			}
 		}
-		log.Info("All worker MachineSets have reached desired replica count")
-		return true, nil
+		return true
 	})
+	if err != nil {
+		return err
+	}
+	log.Info("All worker MachineSets have reached desired replica count")
+	return nil
 }
 
-// WaitForWorkerMachineSets waits for all the cluster-api type worker machinesets in specific to be ready
-func WaitForCAPIMachineSets(capiClient client.Client, clusterID string, namespace string) error {
-	return wait.PollUntilContextTimeout(context.TODO(), time.Second, maxWaitTimeout, true, func(_ context.Context) (done bool, err error) {
-		machineSetList := &capiv1beta1.MachineSetList{}
-		err = capiClient.List(context.TODO(), machineSetList, &client.ListOptions{
-			Namespace: namespace,
-			LabelSelector: labels.SelectorFromSet(map[string]string{
-				"cluster.x-k8s.io/cluster-name": clusterID,
-			}),
-		})
-		if err != nil {
-			return false, err
+// WaitForCAPIMachineDeployment waits for a cluster api machinedeployment to be ready with new replica count
+func WaitForCAPIMachineDeployment(ctx context.Context, capiClient client.WithWatch, namespace string, name string, newReplicaCount int32) error {
+	listOptions := &client.ListOptions{
+		Namespace:     namespace,
+		FieldSelector: fields.OneTermEqualSelector("metadata.name", name),
+	}
+	return waitForObjects(ctx, capiListWatch(ctx, capiClient, &capiv1beta1.MachineDeploymentList{}, listOptions), &capiv1beta1.MachineDeployment{}, func(objs []interface{}) bool {
+		for _, obj := range objs {
+			md := obj.(*capiv1beta1.MachineDeployment)
+			if md.Status.Replicas == md.Status.ReadyReplicas && md.Status.ReadyReplicas == newReplicaCount {
+				return true
+			}
+			log.Debugf("Waiting for MachineDeployment %s to reach %d replicas, currently %d ready", name, newReplicaCount, md.Status.ReadyReplicas)
 		}
+		return false
+	})
+}
+
+// RestoreNodePools restores the nodepools to their original replicas or autoscaling bounds
+func RestoreNodePools(ctx context.Context, dynamicClient dynamic.Interface, nodePools []NodePool) error {
+	var errs []error
+	for _, nodePool := range nodePools {
+		var spec map[string]interface{}
+		if nodePool.AutoScaling {
+			spec = map[string]interface{}{
+				"replicas": nil,
+				"autoScaling": map[string]interface{}{
+					"min": nodePool.MinReplicas,
+					"max": nodePool.MaxReplicas,
+				},
+			}
+		} else {
+			spec = map[string]interface{}{
+				"replicas":    nodePool.Replicas,
+				"autoScaling": nil,
+			}
+		}
+		if _, err := PatchNodePool(ctx, dynamicClient, nodePool, spec); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		log.Infof("NodePool %s restored", nodePool.Name)
+	}
+	return errors.Join(errs...)
+}
 
-		for _, ms := range machineSetList.Items {
+// PatchNodePool merge patches the spec of a nodepool and returns the time of the update
+func PatchNodePool(ctx context.Context, dynamicClient dynamic.Interface, nodePool NodePool, spec map[string]interface{}) (time.Time, error) {
+	patch, err := json.Marshal(map[string]interface{}{"spec": spec})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error building nodepool patch: %v", err)
+	}
+	updateTimestamp := time.Now().UTC().Truncate(time.Second)
+	_, err = dynamicClient.Resource(NodePoolGVR).Namespace(nodePool.Namespace).Patch(ctx, nodePool.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to edit nodepool %s: %v", nodePool.Name, err)
+	}
+	return updateTimestamp, nil
+}
+
+// WaitForNodePool waits for a hypershift nodepool to be ready with new replica count
+func WaitForNodePool(ctx context.Context, dynamicClient dynamic.Interface, namespace string, name string, newReplicaCount int) error {
+	nodePoolClient := dynamicClient.Resource(NodePoolGVR).Namespace(namespace)
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", name).String()
+	listWatch := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = fieldSelector
+			return nodePoolClient.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = fieldSelector
+			return nodePoolClient.Watch(ctx, options)
+		},
+	}
+	return waitForObjects(ctx, listWatch, &unstructured.Unstructured{}, func(objs []interface{}) bool {
+		for _, obj := range objs {
+			replicas, _, _ := unstructured.NestedInt64(obj.(*unstructured.Unstructured).Object, "status", "replicas")
+			if int(replicas) == newReplicaCount {
+				return true
+			}
+			log.Debugf("Waiting for NodePool %s to reach %d replicas, currently %d", name, newReplicaCount, replicas)
+		}
+		return false
+	})
+}
+
+// WaitForCAPIMachineSets waits for all the cluster-api type worker machinesets of the cluster to be ready
+func WaitForCAPIMachineSets(ctx context.Context, capiClient client.WithWatch, clusterID string, namespace string, filter *MachineSetFilter) error {
+	selected, err := selectedMachineDeployments(ctx, capiClient, clusterID, namespace, filter)
+	if err != nil {
+		return err
+	}
+	listOptions := &client.ListOptions{
+		Namespace: namespace,
+		LabelSelector: labels.SelectorFromSet(map[string]string{
+			"cluster.x-k8s.io/cluster-name": clusterID,
+		}),
+	}
+	err = waitForObjects(ctx, capiListWatch(ctx, capiClient, &capiv1beta1.MachineSetList{}, listOptions), &capiv1beta1.MachineSet{}, func(objs []interface{}) bool {
+		for _, obj := range objs {
+			ms := obj.(*capiv1beta1.MachineSet)
+			if !isCAPIObjectSelected(selected, ms.Labels) {
+				continue
+			}
 			if ms.Status.Replicas != ms.Status.ReadyReplicas {
 				log.Debugf("Waiting for MachineSet %s to reach %d replicas, currently %d ready", ms.Name, ms.Status.Replicas, ms.Status.ReadyReplicas)
-				return false, nil
+				return false
 			}
 		}
-
-		log.Info("All worker MachineSets have reached desired replica count")
-		return true, nil
+		return true
 	})
+	if err != nil {
+		return err
+	}
+	log.Info("All worker MachineSets have reached desired replica count")
+	return nil
 }
@@ -26,8 +26,13 @@ import (
 	mmetrics "github.com/kube-burner/kube-burner/pkg/measurements/metrics"
 	mtypes "github.com/kube-burner/kube-burner/pkg/measurements/types"
 	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
 )
 
+// aggregatedLatencies holds the latencies of every phase across all the iterations of a run
+var aggregatedLatencies = make(map[string][]float64)
+var aggregatedLatenciesLock sync.Mutex
+
 // SetupMetrics sets up the measurment factory for us
 func SetupMetrics(uuid string, metadata map[string]interface{}, kubeClientProvider *config.KubeClientProvider) {
 	configSpec := config.Spec{
@@ -49,10 +54,15 @@ func SetupMetrics(uuid string, metadata map[string]interface{}, kubeClientProvid
 	)
 }
 
-// FinalizeMetrics performs and indexes required metrics
-func FinalizeMetrics(machineSetsToEdit *sync.Map, scaledMachineDetails map[string]MachineInfo, metadata map[string]interface{}, indexerValue indexers.Indexer, amiID string, scaleEventEpoch int64) {
+// FinalizeMetrics performs and indexes required metrics, returning the node ready latencies
+func FinalizeMetrics(machineSetsToEdit *sync.Map, scaledMachineDetails map[string]MachineInfo, metadata map[string]interface{}, indexerValue indexers.Indexer, scaleEventEpoch int64, iteration int) []interface{} {
 	nodeMetrics := measurements.GetMetrics()
-	normLatencies, latencyQuantiles, latencyStacked := calculateMetrics(machineSetsToEdit, scaledMachineDetails, metadata, nodeMetrics[0], amiID, scaleEventEpoch)
+	normLatencies, latencyQuantiles, latencyStacked, latencyGroupedStacked := calculateMetrics(machineSetsToEdit, scaledMachineDetails, metadata, nodeMetrics[0], scaleEventEpoch, iteration)
+	aggregatedLatenciesLock.Lock()
+	for phase, latencies := range getQuantileMap(normLatencies) {
+		aggregatedLatencies[phase] = append(aggregatedLatencies[phase], latencies...)
+	}
+	aggregatedLatenciesLock.Unlock()
 	for _, q := range latencyQuantiles {
 		nq := q.(mmetrics.LatencyQuantiles)
 		log.Infof("%s: %s 50th: %v 99th: %v max: %v avg: %v", JobName, nq.QuantileName, nq.P50, nq.P99, nq.Max, nq.Avg)
@@ -62,27 +72,48 @@ func FinalizeMetrics(machineSetsToEdit *sync.Map, scaledMachineDetails map[strin
 		// TODO Deprecate quantiles after full transition to stacked measurements
 		nodeReadyLatencyQuantilesMeasurement: latencyQuantiles,
 		nodeReadyLatencyStackedMeasurement:   latencyStacked,
+		// Breakdowns are kept apart from the per boot image series
+		nodeReadyLatencyGroupedStackedMeasurement: latencyGroupedStacked,
 	}
 	measurements.IndexLatencyMeasurement(mtypes.Measurement{Name: measurementName}, JobName, metricMap, map[string]indexers.Indexer{
 		"": indexerValue,
 	})
+	return normLatencies
+}
+
+// iterationMetadata returns a copy of the metadata carrying the iteration, for the documents without an iteration field
+func iterationMetadata(metadata map[string]interface{}, iteration int) map[string]interface{} {
+	summaryMetadata := make(map[string]interface{}, len(metadata)+1)
+	for key, value := range metadata {
+		summaryMetadata[key] = value
+	}
+	summaryMetadata["iteration"] = iteration
+	return summaryMetadata
 }
 
 // calculateMetrics calculates the metrics for node bootup times
-func calculateMetrics(machineSetsToEdit *sync.Map, scaledMachineDetails map[string]MachineInfo, metadata map[string]interface{}, nodeMetrics *sync.Map, amiID string, scaleEventEpoch int64) ([]interface{}, []interface{}, []interface{}) {
+func calculateMetrics(machineSetsToEdit *sync.Map, scaledMachineDetails map[string]MachineInfo, metadata map[string]interface{}, nodeMetrics *sync.Map, scaleEventEpoch int64, iteration int) ([]interface{}, []interface{}, []interface{}, []interface{}) {
 	var scaleEventTimestamp time.Time
 	var uuid, machineSetName string
-	var normLatencies, latencyQuantiles []interface{}
+	var normLatencies, latencyQuantiles, latencyStacked, latencyGroupedStacked []interface{}
 	for machine, info := range scaledMachineDetails {
-		lastHypenIndex := strings.LastIndex(machine, "-")
-		if lastHypenIndex != (-1) {
-			machineSetName = machine[:lastHypenIndex]
+		machineSetName = info.machineSet
+		if machineSetName == "" {
+			lastHypenIndex := strings.LastIndex(machine, "-")
+			if lastHypenIndex != (-1) {
+				machineSetName = machine[:lastHypenIndex]
+			}
 		}
 		if _, exists := nodeMetrics.Load(info.nodeUID); !exists {
 			continue
 		}
 		if scaleEventEpoch == 0 {
-			msValue, _ := machineSetsToEdit.Load(machineSetName)
+			msValue, exists := machineSetsToEdit.Load(machineSetName)
+			// Machines of machinesets left out of the placement plan, replaced by a health check for instance, have no scale event
+			if !exists {
+				log.Debugf("Skipping machine %s, its machineset %s was not scaled", machine, machineSetName)
+				continue
+			}
 			msInfo := msValue.(MachineSetInfo)
 			scaleEventTimestamp = msInfo.LastUpdatedTime
 		} else {
@@ -93,6 +124,10 @@ func calculateMetrics(machineSetsToEdit *sync.Map, scaledMachineDetails map[stri
 		nmValue, _ := nodeMetrics.Load(info.nodeUID)
 		nodeMetricValue := nmValue.(measurements.NodeMetric)
 		uuid = nodeMetricValue.UUID
+		instanceType := nodeMetricValue.Labels[corev1.LabelInstanceTypeStable]
+		architecture := nodeMetricValue.Labels[corev1.LabelArchStable]
+		zone := nodeMetricValue.Labels[corev1.LabelTopologyZone]
+		region := nodeMetricValue.Labels[corev1.LabelTopologyRegion]
 		// Prevents OS indexing error due to mapping conflicts
 		for key, value := range nodeMetricValue.Labels {
 			newKey := strings.ReplaceAll(key, ".", "_")
@@ -100,59 +135,225 @@ func calculateMetrics(machineSetsToEdit *sync.Map, scaledMachineDetails map[stri
 			delete(nodeMetricValue.Labels, key)
 		}
 		normLatencies = append(normLatencies, NodeReadyMetric{
-			Timestamp:                time.Now().UTC(),
-			ScaleEventTimestamp:      scaleEventTimestamp,
-			MachineCreationTimestamp: machineCreationTimeStamp,
-			MachineCreationLatency:   int(machineCreationTimeStamp.Sub(scaleEventTimestamp).Milliseconds()),
-			MachineReadyTimestamp:    machineReadyTimeStamp,
-			MachineReadyLatency:      int(machineReadyTimeStamp.Sub(scaleEventTimestamp).Milliseconds()),
-			NodeCreationTimestamp:    nodeMetricValue.Timestamp,
-			NodeCreationLatency:      int(nodeMetricValue.Timestamp.Sub(scaleEventTimestamp).Milliseconds()),
-			NodeReadyTimestamp:       nodeMetricValue.NodeReady,
-			NodeReadyLatency:         int(nodeMetricValue.NodeReady.Sub(scaleEventTimestamp).Milliseconds()),
-			MetricName:               nodeReadyLatencyMeasurement,
-			UUID:                     uuid,
-			AMIID:                    amiID,
-			JobName:                  JobName,
-			Name:                     nodeMetricValue.Name,
-			Labels:                   nodeMetricValue.Labels,
-			Metadata:                 metadata,
+			Timestamp:                    time.Now().UTC(),
+			ScaleEventTimestamp:          scaleEventTimestamp,
+			MachineCreationTimestamp:     machineCreationTimeStamp,
+			MachineCreationLatency:       int(machineCreationTimeStamp.Sub(scaleEventTimestamp).Milliseconds()),
+			MachineReadyTimestamp:        machineReadyTimeStamp,
+			MachineReadyLatency:          int(machineReadyTimeStamp.Sub(scaleEventTimestamp).Milliseconds()),
+			NodeCreationTimestamp:        nodeMetricValue.Timestamp,
+			NodeCreationLatency:          int(nodeMetricValue.Timestamp.Sub(scaleEventTimestamp).Milliseconds()),
+			NodeReadyTimestamp:           nodeMetricValue.NodeReady,
+			NodeReadyLatency:             int(nodeMetricValue.NodeReady.Sub(scaleEventTimestamp).Milliseconds()),
+			HostInspectionTimestamp:      info.inspectionTimestamp,
+			HostInspectionLatency:        phaseLatency(info.inspectionTimestamp, scaleEventTimestamp),
+			HostProvisionTimestamp:       info.provisioningTimestamp,
+			HostProvisionLatency:         phaseLatency(info.provisioningTimestamp, scaleEventTimestamp),
+			MachineProvisioningTimestamp: info.timeline["MachineProvisioning"],
+			MachineProvisioningLatency:   phaseLatency(info.timeline["MachineProvisioning"], scaleEventTimestamp),
+			MachineProvisionedTimestamp:  info.timeline["MachineProvisioned"],
+			MachineProvisionedLatency:    phaseLatency(info.timeline["MachineProvisioned"], scaleEventTimestamp),
+			MachineRunningTimestamp:      info.timeline["MachineRunning"],
+			MachineRunningLatency:        phaseLatency(info.timeline["MachineRunning"], scaleEventTimestamp),
+			ProviderIDTimestamp:          info.timeline["ProviderID"],
+			ProviderIDLatency:            phaseLatency(info.timeline["ProviderID"], scaleEventTimestamp),
+			ClientCSRCreationTimestamp:   info.timeline["ClientCSRCreation"],
+			ClientCSRCreationLatency:     phaseLatency(info.timeline["ClientCSRCreation"], scaleEventTimestamp),
+			ClientCSRApprovalTimestamp:   info.timeline["ClientCSRApproval"],
+			ClientCSRApprovalLatency:     phaseLatency(info.timeline["ClientCSRApproval"], scaleEventTimestamp),
+			ServingCSRCreationTimestamp:  info.timeline["ServingCSRCreation"],
+			ServingCSRCreationLatency:    phaseLatency(info.timeline["ServingCSRCreation"], scaleEventTimestamp),
+			ServingCSRApprovalTimestamp:  info.timeline["ServingCSRApproval"],
+			ServingCSRApprovalLatency:    phaseLatency(info.timeline["ServingCSRApproval"], scaleEventTimestamp),
+			ClientCSRPendingLatency:      csrPendingLatency(info.timeline["ClientCSRCreation"], info.timeline["ClientCSRApproval"]),
+			ServingCSRPendingLatency:     csrPendingLatency(info.timeline["ServingCSRCreation"], info.timeline["ServingCSRApproval"]),
+			NetworkReadyTimestamp:        info.timeline["NetworkReady"],
+			NetworkReadyLatency:          phaseLatency(info.timeline["NetworkReady"], scaleEventTimestamp),
+			MachineConfigDoneTimestamp:   info.timeline["MachineConfigDone"],
+			MachineConfigDoneLatency:     phaseLatency(info.timeline["MachineConfigDone"], scaleEventTimestamp),
+			FirstPodScheduledTimestamp:   info.timeline["FirstPodScheduled"],
+			FirstPodScheduledLatency:     phaseLatency(info.timeline["FirstPodScheduled"], scaleEventTimestamp),
+			MetricName:                   nodeReadyLatencyMeasurement,
+			UUID:                         uuid,
+			AMIID:                        info.bootImageID,
+			BootImageID:                  info.bootImageID,
+			Iteration:                    iteration,
+			JobName:                      JobName,
+			Name:                         nodeMetricValue.Name,
+			Labels:                       nodeMetricValue.Labels,
+			Metadata:                     metadata,
+			MachineSet:                   machineSetName,
+			InstanceType:                 instanceType,
+			Architecture:                 architecture,
+			Zone:                         zone,
+			Region:                       region,
 		})
 	}
+	for condition, latencies := range getQuantileMap(normLatencies) {
+		latencySummary := mmetrics.NewLatencySummary(latencies, condition)
+		latencySummary.UUID = uuid
+		latencySummary.MetricName = nodeReadyLatencyQuantilesMeasurement
+		latencySummary.JobName = JobName
+		latencySummary.Metadata = iterationMetadata(metadata, iteration)
+		latencyQuantiles = append(latencyQuantiles, latencySummary)
+	}
+
+	// One stacked measurement per boot image, so that images can be compared within a single run
+	bootImageLatencies := make(map[string][]interface{})
+	for _, normLatency := range normLatencies {
+		bootImageID := normLatency.(NodeReadyMetric).BootImageID
+		bootImageLatencies[bootImageID] = append(bootImageLatencies[bootImageID], normLatency)
+	}
+	for bootImageID, imageLatencies := range bootImageLatencies {
+		imageStacked := NodeReadyLatencyStackedMeasurement{
+			UUID:        uuid,
+			JobName:     JobName,
+			BootImageID: bootImageID,
+			Iteration:   iteration,
+			Metadata:    metadata,
+			Timestamp:   time.Now().UTC(),
+			MetricName:  nodeReadyLatencyStackedMeasurement,
+		}
+		setGroupDimensions(&imageStacked, imageLatencies)
+		latencyStacked = append(latencyStacked, calculateStackedLatencies(imageLatencies, imageStacked))
+	}
+
+	// Stacked measurements per machineset, instance type, architecture, zone and region, so that a slow one is not diluted in the fleet
+	groupedLatencies := make(map[string]map[string][]interface{})
+	for _, normLatency := range normLatencies {
+		for groupBy, group := range nodeReadyMetricGroups(normLatency.(NodeReadyMetric)) {
+			if group == "" {
+				continue
+			}
+			if _, exists := groupedLatencies[groupBy]; !exists {
+				groupedLatencies[groupBy] = make(map[string][]interface{})
+			}
+			groupedLatencies[groupBy][group] = append(groupedLatencies[groupBy][group], normLatency)
+		}
+	}
+	for groupBy, groups := range groupedLatencies {
+		for group, groupLatencies := range groups {
+			groupStacked := NodeReadyLatencyStackedMeasurement{
+				UUID:       uuid,
+				JobName:    JobName,
+				GroupBy:    groupBy,
+				Group:      group,
+				Iteration:  iteration,
+				Metadata:   metadata,
+				Timestamp:  time.Now().UTC(),
+				MetricName: nodeReadyLatencyGroupedStackedMeasurement,
+			}
+			setGroupDimensions(&groupStacked, groupLatencies)
+			latencyGroupedStacked = append(latencyGroupedStacked, calculateStackedLatencies(groupLatencies, groupStacked))
+		}
+	}
+	return normLatencies, latencyQuantiles, latencyStacked, latencyGroupedStacked
+}
+
+// nodeReadyMetricGroups returns the value of every stacked measurement group for a node
+func nodeReadyMetricGroups(nodeReadyMetric NodeReadyMetric) map[string]string {
+	return map[string]string{
+		machineSetGroup:   nodeReadyMetric.MachineSet,
+		instanceTypeGroup: nodeReadyMetric.InstanceType,
+		architectureGroup: nodeReadyMetric.Architecture,
+		zoneGroup:         nodeReadyMetric.Zone,
+		regionGroup:       nodeReadyMetric.Region,
+	}
+}
+
+// setGroupDimensions sets the dimensions shared by every node of a group on its stacked measurement
+func setGroupDimensions(latencyStacked *NodeReadyLatencyStackedMeasurement, groupLatencies []interface{}) {
+	dimensions := nodeReadyMetricGroups(groupLatencies[0].(NodeReadyMetric))
+	for _, groupLatency := range groupLatencies[1:] {
+		for groupBy, group := range nodeReadyMetricGroups(groupLatency.(NodeReadyMetric)) {
+			if dimensions[groupBy] != group {
+				dimensions[groupBy] = ""
+			}
+		}
+	}
+	latencyStacked.MachineSet = dimensions[machineSetGroup]
+	latencyStacked.InstanceType = dimensions[instanceTypeGroup]
+	latencyStacked.Architecture = dimensions[architectureGroup]
+	latencyStacked.Zone = dimensions[zoneGroup]
+	latencyStacked.Region = dimensions[regionGroup]
+}
+
+// GetIterationSummary summarizes the latencies of every phase across all the iterations
+func GetIterationSummary() map[string]interface{} {
+	aggregatedLatenciesLock.Lock()
+	defer aggregatedLatenciesLock.Unlock()
+	iterationSummary := make(map[string]interface{})
+	for phase, latencies := range aggregatedLatencies {
+		latencySummary := mmetrics.NewLatencySummary(latencies, phase)
+		iterationSummary[phase] = map[string]int{
+			"P99": latencySummary.P99,
+			"P95": latencySummary.P95,
+			"P50": latencySummary.P50,
+			"min": latencySummary.Min,
+			"max": latencySummary.Max,
+			"avg": latencySummary.Avg,
+		}
+	}
+	return iterationSummary
+}
+
+// getQuantileMap groups the latencies of every phase
+func getQuantileMap(normLatencies []interface{}) map[string][]float64 {
 	quantileMap := map[string][]float64{}
 	for _, normLatency := range normLatencies {
 		quantileMap["MachineCreation"] = append(quantileMap["MachineCreation"], float64(normLatency.(NodeReadyMetric).MachineCreationLatency))
 		quantileMap["MachineReady"] = append(quantileMap["MachineReady"], float64(normLatency.(NodeReadyMetric).MachineReadyLatency))
 		quantileMap["NodeCreation"] = append(quantileMap["NodeCreation"], float64(normLatency.(NodeReadyMetric).NodeCreationLatency))
 		quantileMap["NodeReady"] = append(quantileMap["NodeReady"], float64(normLatency.(NodeReadyMetric).NodeReadyLatency))
+		// Platform specific provisioning phases and lifecycle steps are only reported when observed
+		phaseLatencies := map[string]int{
+			"HostInspection":      normLatency.(NodeReadyMetric).HostInspectionLatency,
+			"HostProvision":       normLatency.(NodeReadyMetric).HostProvisionLatency,
+			"MachineProvisioning": normLatency.(NodeReadyMetric).MachineProvisioningLatency,
+			"MachineProvisioned":  normLatency.(NodeReadyMetric).MachineProvisionedLatency,
+			"MachineRunning":      normLatency.(NodeReadyMetric).MachineRunningLatency,
+			"ProviderID":          normLatency.(NodeReadyMetric).ProviderIDLatency,
+			"ClientCSRCreation":   normLatency.(NodeReadyMetric).ClientCSRCreationLatency,
+			"ClientCSRApproval":   normLatency.(NodeReadyMetric).ClientCSRApprovalLatency,
+			"ServingCSRCreation":  normLatency.(NodeReadyMetric).ServingCSRCreationLatency,
+			"ServingCSRApproval":  normLatency.(NodeReadyMetric).ServingCSRApprovalLatency,
+			"ClientCSRPending":    normLatency.(NodeReadyMetric).ClientCSRPendingLatency,
+			"ServingCSRPending":   normLatency.(NodeReadyMetric).ServingCSRPendingLatency,
+			"NetworkReady":        normLatency.(NodeReadyMetric).NetworkReadyLatency,
+			"MachineConfigDone":   normLatency.(NodeReadyMetric).MachineConfigDoneLatency,
+			"FirstPodScheduled":   normLatency.(NodeReadyMetric).FirstPodScheduledLatency,
+		}
+		for phase, latency := range phaseLatencies {
+			if latency != 0 {
+				quantileMap[phase] = append(quantileMap[phase], float64(latency))
+			}
+		}
 	}
+	return quantileMap
+}
 
-	latencyStacked := NodeReadyLatencyStackedMeasurement{
-		UUID:        uuid,
-		JobName:     JobName,
-		BootImageID: amiID,
-		Metadata:    metadata,
-		Timestamp:   time.Now().UTC(),
-		MetricName:  nodeReadyLatencyStackedMeasurement,
-	}
-
-	calcSummary := func(name string, latencies []float64) mmetrics.LatencyQuantiles {
+// calculateStackedLatencies fills the stacked measurement with the quantiles of the given latencies
+func calculateStackedLatencies(normLatencies []interface{}, latencyStacked NodeReadyLatencyStackedMeasurement) NodeReadyLatencyStackedMeasurement {
+	for name, latencies := range getQuantileMap(normLatencies) {
+		// Stacked measurement only carries the phases common to every platform
+		if !reflect.ValueOf(&latencyStacked).Elem().FieldByName(name + "_" + "P99").IsValid() {
+			continue
+		}
 		latencySummary := mmetrics.NewLatencySummary(latencies, name)
-		latencySummary.UUID = uuid
-		latencySummary.MetricName = nodeReadyLatencyQuantilesMeasurement
-		latencySummary.JobName = JobName
-		latencySummary.Metadata = metadata
 		reflect.ValueOf(&latencyStacked).Elem().FieldByName(name + "_" + "P99").Set(reflect.ValueOf(latencySummary.P99))
 		reflect.ValueOf(&latencyStacked).Elem().FieldByName(name + "_" + "P95").Set(reflect.ValueOf(latencySummary.P95))
 		reflect.ValueOf(&latencyStacked).Elem().FieldByName(name + "_" + "P50").Set(reflect.ValueOf(latencySummary.P50))
 		reflect.ValueOf(&latencyStacked).Elem().FieldByName(name + "_" + "Min").Set(reflect.ValueOf(latencySummary.Min))
 		reflect.ValueOf(&latencyStacked).Elem().FieldByName(name + "_" + "Max").Set(reflect.ValueOf(latencySummary.Max))
 		reflect.ValueOf(&latencyStacked).Elem().FieldByName(name + "_" + "Avg").Set(reflect.ValueOf(latencySummary.Avg))
-		return latencySummary
 	}
+	return latencyStacked
+}
 
-	for condition, latencies := range quantileMap {
-		latencyQuantiles = append(latencyQuantiles, calcSummary(condition, latencies))
+// phaseLatency calculates the latency of an optional phase, zero when the phase was not observed
+func phaseLatency(phaseTimestamp time.Time, scaleEventTimestamp time.Time) int {
+	if phaseTimestamp.IsZero() {
+		return 0
 	}
-	return normLatencies, latencyQuantiles, []interface{}{latencyStacked}
+	return int(phaseTimestamp.Sub(scaleEventTimestamp).Milliseconds())
 }
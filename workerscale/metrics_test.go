@@ -0,0 +1,128 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerscale
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/measurements"
+	mmetrics "github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+)
+
+// newTestNodeMetrics returns the node metrics of the given nodes, ready a minute after the scale event
+func newTestNodeMetrics(scaleEvent time.Time, nodeUIDs ...string) *sync.Map {
+	nodeMetrics := &sync.Map{}
+	for _, nodeUID := range nodeUIDs {
+		nodeMetrics.Store(nodeUID, measurements.NodeMetric{
+			Timestamp: scaleEvent.Add(30 * time.Second),
+			NodeReady: scaleEvent.Add(time.Minute),
+			UUID:      "uuid",
+			Name:      nodeUID,
+			Labels:    map[string]string{},
+		})
+	}
+	return nodeMetrics
+}
+
+func TestCalculateMetricsSkipsMachinesOutsideThePlan(t *testing.T) {
+	scaleEvent := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	machineSetsToEdit := &sync.Map{}
+	machineSetsToEdit.Store("planned", MachineSetInfo{LastUpdatedTime: scaleEvent, PrevReplicas: 0, CurrentReplicas: 1})
+	scaledMachineDetails := map[string]MachineInfo{
+		"planned-abcde": {
+			nodeUID:           "planned-node",
+			machineSet:        "planned",
+			creationTimestamp: scaleEvent.Add(5 * time.Second),
+			readyTimestamp:    scaleEvent.Add(20 * time.Second),
+		},
+		// Replaced by a machine health check in a machineset left out of the plan
+		"unplanned-fghij": {
+			nodeUID:           "unplanned-node",
+			machineSet:        "unplanned",
+			creationTimestamp: scaleEvent.Add(5 * time.Second),
+			readyTimestamp:    scaleEvent.Add(20 * time.Second),
+		},
+	}
+	nodeMetrics := newTestNodeMetrics(scaleEvent, "planned-node", "unplanned-node")
+	normLatencies, _, _, _ := calculateMetrics(machineSetsToEdit, scaledMachineDetails, map[string]interface{}{}, nodeMetrics, 0, 1)
+	if len(normLatencies) != 1 {
+		t.Fatalf("got %d node latencies, expected 1", len(normLatencies))
+	}
+	nodeReadyMetric := normLatencies[0].(NodeReadyMetric)
+	if nodeReadyMetric.MachineSet != "planned" {
+		t.Errorf("got machineset %s, expected planned", nodeReadyMetric.MachineSet)
+	}
+	if nodeReadyMetric.NodeReadyLatency != 60000 {
+		t.Errorf("got node ready latency %d, expected 60000", nodeReadyMetric.NodeReadyLatency)
+	}
+}
+
+func TestCalculateMetricsKeepsGroupedStackedApart(t *testing.T) {
+	scaleEvent := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	machineSetsToEdit := &sync.Map{}
+	machineSetsToEdit.Store("workers", MachineSetInfo{LastUpdatedTime: scaleEvent, PrevReplicas: 0, CurrentReplicas: 1})
+	scaledMachineDetails := map[string]MachineInfo{
+		"workers-abcde": {
+			nodeUID:           "node",
+			machineSet:        "workers",
+			bootImageID:       "ami-0123456789abcdef0",
+			creationTimestamp: scaleEvent.Add(5 * time.Second),
+			readyTimestamp:    scaleEvent.Add(20 * time.Second),
+		},
+	}
+	_, _, latencyStacked, latencyGroupedStacked := calculateMetrics(machineSetsToEdit, scaledMachineDetails, map[string]interface{}{}, newTestNodeMetrics(scaleEvent, "node"), 0, 1)
+	if len(latencyStacked) != 1 {
+		t.Fatalf("got %d stacked measurements, expected one per boot image", len(latencyStacked))
+	}
+	if stacked := latencyStacked[0].(NodeReadyLatencyStackedMeasurement); stacked.MetricName != nodeReadyLatencyStackedMeasurement || stacked.GroupBy != "" || stacked.BootImageID != "ami-0123456789abcdef0" {
+		t.Errorf("unexpected stacked measurement %+v", stacked)
+	}
+	if len(latencyGroupedStacked) != 1 {
+		t.Fatalf("got %d grouped stacked measurements, expected the machineset one", len(latencyGroupedStacked))
+	}
+	if grouped := latencyGroupedStacked[0].(NodeReadyLatencyStackedMeasurement); grouped.MetricName != nodeReadyLatencyGroupedStackedMeasurement || grouped.GroupBy != machineSetGroup || grouped.Group != "workers" {
+		t.Errorf("unexpected grouped stacked measurement %+v", grouped)
+	}
+}
+
+func TestCalculateMetricsQuantilesCarryTheIteration(t *testing.T) {
+	scaleEvent := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	machineSetsToEdit := &sync.Map{}
+	machineSetsToEdit.Store("workers", MachineSetInfo{LastUpdatedTime: scaleEvent, PrevReplicas: 0, CurrentReplicas: 1})
+	scaledMachineDetails := map[string]MachineInfo{
+		"workers-abcde": {
+			nodeUID:           "node",
+			machineSet:        "workers",
+			creationTimestamp: scaleEvent.Add(5 * time.Second),
+			readyTimestamp:    scaleEvent.Add(20 * time.Second),
+		},
+	}
+	metadata := map[string]interface{}{"platform": "AWS"}
+	_, latencyQuantiles, _, _ := calculateMetrics(machineSetsToEdit, scaledMachineDetails, metadata, newTestNodeMetrics(scaleEvent, "node"), 0, 2)
+	if len(latencyQuantiles) == 0 {
+		t.Fatal("expected quantile measurements")
+	}
+	for _, latencyQuantile := range latencyQuantiles {
+		summaryMetadata := latencyQuantile.(mmetrics.LatencyQuantiles).Metadata.(map[string]interface{})
+		if summaryMetadata["iteration"] != 2 || summaryMetadata["platform"] != "AWS" {
+			t.Errorf("unexpected quantile metadata %v", summaryMetadata)
+		}
+	}
+	if _, exists := metadata["iteration"]; exists {
+		t.Error("the shared metadata must not carry the iteration")
+	}
+}
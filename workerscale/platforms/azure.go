@@ -0,0 +1,53 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rosa
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+	wscale "github.com/vishnuchalla/workers-scale/workerscale"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+type AzureScenario struct {
+	ARO bool
+}
+
+// Returns a new scenario object
+func (azureScenario *AzureScenario) OrchestrateWorkload(ctx context.Context, scaleConfig wscale.ScaleConfig) (string, error) {
+	if azureScenario.ARO {
+		scaleConfig.Metadata[wscale.ClusterType] = wscale.ARO
+	}
+	return orchestrateMachineAPIWorkload(ctx, scaleConfig, wscale.AzurePlatform)
+}
+
+// IsARO verifies if the cluster is managed by Azure Red Hat OpenShift
+func IsARO(ctx context.Context, dynamicClient dynamic.Interface) bool {
+	aroClusterGVR := schema.GroupVersionResource{
+		Group:    "aro.openshift.io",
+		Version:  "v1alpha1",
+		Resource: "clusters",
+	}
+
+	_, err := dynamicClient.Resource(aroClusterGVR).Get(ctx, "cluster", metav1.GetOptions{})
+	if err != nil {
+		log.Debugf("ARO cluster resource not found: %v", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,34 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rosa
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+	wscale "github.com/vishnuchalla/workers-scale/workerscale"
+)
+
+type GCPScenario struct{}
+
+// Returns a new scenario object
+func (gcpScenario *GCPScenario) OrchestrateWorkload(ctx context.Context, scaleConfig wscale.ScaleConfig) (string, error) {
+	// GCP clusters have a machineset per zone, hence workers are spread across zones unless another placement is requested
+	if scaleConfig.PlacementStrategy == "" && scaleConfig.EphemeralMachineSet.Template == "" {
+		log.Infof("Using the %s placement across the GCP zones", wscale.ZoneRoundRobinPlacement)
+		scaleConfig.PlacementStrategy = wscale.ZoneRoundRobinPlacement
+	}
+	return orchestrateMachineAPIWorkload(ctx, scaleConfig, wscale.GCPPlatform)
+}
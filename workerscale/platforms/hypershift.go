@@ -0,0 +1,378 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rosa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kube-burner/kube-burner/pkg/config"
+	"github.com/kube-burner/kube-burner/pkg/measurements"
+	log "github.com/sirupsen/logrus"
+	wscale "github.com/vishnuchalla/workers-scale/workerscale"
+	core "github.com/vishnuchalla/workers-scale/workerscale/core"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+)
+
+type HyperShiftScenario struct{}
+
+// Returns a new scenario object
+func (hyperShiftScenario *HyperShiftScenario) OrchestrateWorkload(ctx context.Context, scaleConfig wscale.ScaleConfig) (string, error) {
+	var err error
+	var triggerJob string
+	var triggerTime time.Time
+
+	if scaleConfig.MCKubeConfig == "" {
+		return "", fmt.Errorf("error reading management cluster kubeconfig. Please provide a valid path")
+	}
+	kubeClientProvider := config.NewKubeClientProvider("", "")
+	clientSet, restConfig := kubeClientProvider.ClientSet(0, 0)
+	mcKubeClientProvider := config.NewKubeClientProvider(scaleConfig.MCKubeConfig, "")
+	mcClientSet, mcRestConfig := mcKubeClientProvider.ClientSet(0, 0)
+	mcDynamicClient := dynamic.NewForConfigOrDie(mcRestConfig)
+	capiClient, err := wscale.GetCAPIClient(mcRestConfig)
+	if err != nil {
+		return "", err
+	}
+	externalID, err := getExternalClusterID(ctx, dynamic.NewForConfigOrDie(restConfig))
+	if err != nil {
+		return "", err
+	}
+	hostedCluster, err := getHostedCluster(ctx, mcDynamicClient, externalID)
+	if err != nil {
+		return "", err
+	}
+	infraID, _, _ := unstructured.NestedString(hostedCluster.Object, "spec", "infraID")
+	hcpNamespace, err := wscale.GetHCNamespace(ctx, mcClientSet, hostedCluster.GetNamespace()+"-"+hostedCluster.GetName())
+	if err != nil {
+		return "", err
+	}
+	nodePools, err := getNodePools(ctx, mcDynamicClient, hostedCluster)
+	if err != nil {
+		return "", err
+	}
+	recordNodePoolImages(scaleConfig.Metadata, nodePools)
+
+	if scaleConfig.ScaleEventEpoch != 0 && !scaleConfig.AutoScalerEnabled {
+		log.Info("Scale event epoch time specified. Hence calculating node latencies without any scaling")
+		wscale.SetupMetrics(scaleConfig.UUID, scaleConfig.Metadata, kubeClientProvider)
+		measurements.Start()
+		scaleUpCtx, cancel := context.WithTimeout(ctx, scaleConfig.ScaleUpTimeout)
+		defer cancel()
+		if err = wscale.WaitForNodes(scaleUpCtx, clientSet); err != nil {
+			return "", wscale.CheckFailedCAPIMachines(ctx, capiClient, mcClientSet, infraID, hcpNamespace, time.Unix(scaleConfig.ScaleEventEpoch, 0).UTC(), scaleConfig, fmt.Errorf("error waiting for nodes: %v", err))
+		}
+		scaledMachineDetails, amiID, err := wscale.GetCapiMachines(ctx, capiClient, scaleConfig.ScaleEventEpoch, infraID, hcpNamespace, nil)
+		if err != nil {
+			return "", err
+		}
+		if err = wscale.GetLifecycleTimelines(ctx, clientSet, scaledMachineDetails); err != nil {
+			return "", err
+		}
+		if err := measurements.Stop(); err != nil {
+			return "", err
+		}
+		wscale.FinalizeMetrics(&sync.Map{}, scaledMachineDetails, scaleConfig.Metadata, scaleConfig.Indexer, scaleConfig.ScaleEventEpoch, scaleConfig.Iteration)
+		return amiID, nil
+	} else {
+		prevMachineDetails, _, err := wscale.GetCapiMachines(ctx, capiClient, 0, infraID, hcpNamespace, nil)
+		if err != nil {
+			return "", err
+		}
+		wscale.SetupMetrics(scaleConfig.UUID, scaleConfig.Metadata, kubeClientProvider)
+		measurements.Start()
+		podProbe, err := wscale.StartPodProbe(ctx, clientSet, scaleConfig.ProbeImage)
+		if err != nil {
+			return "", err
+		}
+		defer podProbe.Cancel()
+		scaleUpCtx, cancelScaleUp := context.WithTimeout(ctx, scaleConfig.ScaleUpTimeout)
+		defer cancelScaleUp()
+		wscale.RecordNodePools(scaleConfig.MCKubeConfig, nodePools)
+		wscale.RegisterRollback(wscale.NodePoolsRollback, func() error {
+			return wscale.RestoreNodePools(context.Background(), mcDynamicClient, nodePools)
+		})
+		scaleUpTimestamp := time.Now().UTC().Truncate(time.Second)
+		nodePoolsToEdit, err := editNodePools(scaleUpCtx, mcDynamicClient, nodePools, scaleConfig.AdditionalWorkerNodes, scaleConfig.AutoScalerEnabled)
+		if err != nil {
+			return "", err
+		}
+		if scaleConfig.AutoScalerEnabled {
+			if triggerJob, triggerTime, err = core.CreateBatchJob(scaleUpCtx, clientSet); err != nil {
+				return "", err
+			}
+			wscale.RegisterRollback(wscale.BatchJobRollback, func() error {
+				return core.DeleteBatchJob(context.Background(), clientSet, triggerJob)
+			})
+			nodePoolsToEdit.Range(func(key, value interface{}) bool {
+				npInfo := value.(wscale.MachineSetInfo)
+				npInfo.LastUpdatedTime = triggerTime
+				nodePoolsToEdit.Store(key, npInfo)
+				return true
+			})
+			// Slightly more delay for the cluster autoscaler to react
+			if err = wscale.Sleep(scaleUpCtx, 5*time.Minute); err != nil {
+				return "", err
+			}
+		}
+		log.Info("Waiting for the nodepools to be ready")
+		if err = waitForNodePools(scaleUpCtx, mcDynamicClient, nodePools, nodePoolsToEdit, true); err != nil {
+			err = checkNodePoolConditions(ctx, mcDynamicClient, nodePools, err)
+			return "", wscale.CheckFailedCAPIMachines(ctx, capiClient, mcClientSet, infraID, hcpNamespace, scaleUpTimestamp, scaleConfig, err)
+		}
+		if err = wscale.WaitForCAPIMachineSets(scaleUpCtx, capiClient, infraID, hcpNamespace, nil); err != nil {
+			return "", wscale.CheckFailedCAPIMachines(ctx, capiClient, mcClientSet, infraID, hcpNamespace, scaleUpTimestamp, scaleConfig, fmt.Errorf("error waiting for MachineSets to be ready: %v", err))
+		}
+		if err = wscale.WaitForNodes(scaleUpCtx, clientSet); err != nil {
+			return "", fmt.Errorf("error waiting for nodes: %v", err)
+		}
+		scaledMachineDetails, amiID, err := wscale.GetCapiMachines(ctx, capiClient, 0, infraID, hcpNamespace, nil)
+		if err != nil {
+			return "", err
+		}
+		wscale.DiscardPreviousMachines(prevMachineDetails, scaledMachineDetails)
+		if err = wscale.GetLifecycleTimelines(ctx, clientSet, scaledMachineDetails); err != nil {
+			return "", err
+		}
+		if err := measurements.Stop(); err != nil {
+			return "", err
+		}
+		probeResults := podProbe.Stop(ctx)
+		wscale.FinalizeMetrics(nodePoolsToEdit, scaledMachineDetails, scaleConfig.Metadata, scaleConfig.Indexer, 0, scaleConfig.Iteration)
+		wscale.FinalizePodProbeMetrics(scaleConfig.UUID, scaleConfig.ProbeImage, probeResults, scaleConfig.Metadata, scaleConfig.Indexer, scaleConfig.Iteration)
+		if scaleConfig.AutoScalerEnabled {
+			if err = core.DeleteBatchJob(ctx, clientSet, triggerJob); err != nil {
+				return "", err
+			}
+			wscale.UnregisterRollback(wscale.BatchJobRollback)
+		}
+		if scaleConfig.GC {
+			log.Info("Restoring nodepools to previous state")
+			scaleDownCtx, cancelScaleDown := context.WithTimeout(ctx, scaleConfig.ScaleDownTimeout)
+			defer cancelScaleDown()
+			if err = wscale.RestoreNodePools(scaleDownCtx, mcDynamicClient, nodePools); err != nil {
+				return "", err
+			}
+			log.Info("Waiting for the nodepools to scale down")
+			if err = waitForNodePools(scaleDownCtx, mcDynamicClient, nodePools, nodePoolsToEdit, false); err != nil {
+				return "", err
+			}
+			if err = wscale.WaitForCAPIMachineSets(scaleDownCtx, capiClient, infraID, hcpNamespace, nil); err != nil {
+				return "", fmt.Errorf("error waiting for MachineSets to scale down: %v", err)
+			}
+		}
+		wscale.UnregisterRollback(wscale.NodePoolsRollback)
+		wscale.CompleteRunState(scaleConfig.GC)
+		return amiID, nil
+	}
+}
+
+// getHostedCluster fetches the hosted cluster matching the clusterID from the management cluster
+func getHostedCluster(ctx context.Context, dynamicClient dynamic.Interface, clusterID string) (*unstructured.Unstructured, error) {
+	hostedClusterGVR := schema.GroupVersionResource{
+		Group:    "hypershift.openshift.io",
+		Version:  "v1beta1",
+		Resource: "hostedclusters",
+	}
+
+	hostedClusters, err := dynamicClient.Resource(hostedClusterGVR).Namespace("").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing hosted clusters: %v", err)
+	}
+	for _, hostedCluster := range hostedClusters.Items {
+		hcClusterID, _, _ := unstructured.NestedString(hostedCluster.Object, "spec", "clusterID")
+		if hcClusterID == clusterID {
+			log.Infof("Found hosted cluster %s/%s", hostedCluster.GetNamespace(), hostedCluster.GetName())
+			return &hostedCluster, nil
+		}
+	}
+	return nil, fmt.Errorf("no hosted cluster found with cluster ID %s", clusterID)
+}
+
+// getNodePools lists the nodepools of a hosted cluster
+func getNodePools(ctx context.Context, dynamicClient dynamic.Interface, hostedCluster *unstructured.Unstructured) ([]wscale.NodePool, error) {
+	var nodePools []wscale.NodePool
+	nodePoolList, err := dynamicClient.Resource(wscale.NodePoolGVR).Namespace(hostedCluster.GetNamespace()).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing nodepools: %v", err)
+	}
+	for _, np := range nodePoolList.Items {
+		if clusterName, _, _ := unstructured.NestedString(np.Object, "spec", "clusterName"); clusterName != hostedCluster.GetName() {
+			continue
+		}
+		nodePool := wscale.NodePool{
+			Name:      np.GetName(),
+			Namespace: np.GetNamespace(),
+		}
+		if minReplicas, found, _ := unstructured.NestedInt64(np.Object, "spec", "autoScaling", "min"); found {
+			maxReplicas, _, _ := unstructured.NestedInt64(np.Object, "spec", "autoScaling", "max")
+			nodePool.AutoScaling = true
+			nodePool.MinReplicas = int(minReplicas)
+			nodePool.MaxReplicas = int(maxReplicas)
+		}
+		replicas, _, _ := unstructured.NestedInt64(np.Object, "spec", "replicas")
+		if nodePool.AutoScaling {
+			replicas, _, _ = unstructured.NestedInt64(np.Object, "status", "replicas")
+		}
+		nodePool.Replicas = int(replicas)
+		nodePool.ReleaseImage, _, _ = unstructured.NestedString(np.Object, "spec", "release", "image")
+		nodePool.PlatformImage = getNodePoolPlatformImage(&np)
+		nodePools = append(nodePools, nodePool)
+	}
+	if len(nodePools) == 0 {
+		return nil, fmt.Errorf("no nodepool found. Aborting execution")
+	}
+	log.Debugf("NodePools: %v", nodePools)
+	return nodePools, nil
+}
+
+// getNodePoolPlatformImage extracts the boot image configured for the nodepool platform
+func getNodePoolPlatformImage(nodePool *unstructured.Unstructured) string {
+	imagePaths := [][]string{
+		{"spec", "platform", "aws", "ami"},
+		{"spec", "platform", "azure", "image", "imageID"},
+		{"spec", "platform", "kubevirt", "rootVolume", "diskImage", "containerDiskImage"},
+	}
+	for _, imagePath := range imagePaths {
+		if image, found, _ := unstructured.NestedString(nodePool.Object, imagePath...); found && image != "" {
+			return image
+		}
+	}
+	return ""
+}
+
+// recordNodePoolImages adds the release and platform images of every nodepool to the metadata
+func recordNodePoolImages(metadata map[string]interface{}, nodePools []wscale.NodePool) {
+	nodePoolImages := make(map[string]interface{})
+	for _, nodePool := range nodePools {
+		nodePoolImages[nodePool.Name] = map[string]string{
+			"releaseImage":  nodePool.ReleaseImage,
+			"platformImage": nodePool.PlatformImage,
+		}
+	}
+	metadata["nodePools"] = nodePoolImages
+}
+
+// editNodePools spreads the additional workers across the nodepools
+func editNodePools(ctx context.Context, dynamicClient dynamic.Interface, nodePools []wscale.NodePool, additionalWorkerNodes int, autoScalerEnabled bool) (*sync.Map, error) {
+	nodePoolsToEdit := sync.Map{}
+	quotient := additionalWorkerNodes / len(nodePools)
+	remainder := additionalWorkerNodes % len(nodePools)
+	for index, nodePool := range nodePools {
+		desiredReplicas := nodePool.Replicas + quotient
+		if index < remainder {
+			desiredReplicas++
+		}
+		var spec map[string]interface{}
+		if autoScalerEnabled {
+			spec = map[string]interface{}{
+				"replicas": nil,
+				"autoScaling": map[string]interface{}{
+					"min": nodePool.Replicas,
+					"max": desiredReplicas,
+				},
+			}
+		} else {
+			spec = map[string]interface{}{
+				"replicas":    desiredReplicas,
+				"autoScaling": nil,
+			}
+		}
+		updateTimestamp, err := wscale.PatchNodePool(ctx, dynamicClient, nodePool, spec)
+		if err != nil {
+			return nil, err
+		}
+		nodePoolsToEdit.Store(nodePool.Name, wscale.MachineSetInfo{
+			LastUpdatedTime: updateTimestamp,
+			PrevReplicas:    nodePool.Replicas,
+			CurrentReplicas: desiredReplicas,
+		})
+		log.Infof("NodePool %s edited to %d replicas", nodePool.Name, desiredReplicas)
+	}
+	return &nodePoolsToEdit, nil
+}
+
+// waitForNodePools waits for the nodepools to reach either the scaled or the original replica count
+func waitForNodePools(ctx context.Context, dynamicClient dynamic.Interface, nodePools []wscale.NodePool, nodePoolsToEdit *sync.Map, isScaleUp bool) error {
+	var wg sync.WaitGroup
+	var errsLock sync.Mutex
+	var errs []error
+	for _, nodePool := range nodePools {
+		npValue, _ := nodePoolsToEdit.Load(nodePool.Name)
+		npInfo := npValue.(wscale.MachineSetInfo)
+		replicas := npInfo.PrevReplicas
+		if isScaleUp {
+			replicas = npInfo.CurrentReplicas
+		}
+		wg.Add(1)
+		go func(np wscale.NodePool, r int) {
+			defer wg.Done()
+			if err := wscale.WaitForNodePool(ctx, dynamicClient, np.Namespace, np.Name, r); err != nil {
+				errsLock.Lock()
+				errs = append(errs, fmt.Errorf("failed waiting for NodePool %s: %v", np.Name, err))
+				errsLock.Unlock()
+			}
+		}(nodePool, replicas)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	log.Infof("All the nodepools have reached desired replica count")
+	return nil
+}
+
+// checkNodePoolConditions explains a nodepool scale up error with the nodepool conditions reporting a problem
+func checkNodePoolConditions(ctx context.Context, dynamicClient dynamic.Interface, nodePools []wscale.NodePool, scaleErr error) error {
+	diagnosticsCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+	defer cancel()
+	var reasons []string
+	for _, nodePool := range nodePools {
+		np, err := dynamicClient.Resource(wscale.NodePoolGVR).Namespace(nodePool.Namespace).Get(diagnosticsCtx, nodePool.Name, metav1.GetOptions{})
+		if err != nil {
+			log.Warnf("Unable to get nodepool %s: %v", nodePool.Name, err)
+			continue
+		}
+		conditions, _, _ := unstructured.NestedSlice(np.Object, "status", "conditions")
+		for _, condition := range conditions {
+			conditionMap, ok := condition.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			conditionType, _ := conditionMap["type"].(string)
+			status, _ := conditionMap["status"].(string)
+			// Only these conditions are healthy when true, others such as UpdatingVersion are informative
+			healthCondition := conditionType == "Ready" || conditionType == "AllMachinesReady" || conditionType == "AllNodesHealthy" || strings.HasPrefix(conditionType, "Valid")
+			if !healthCondition || status == "True" {
+				continue
+			}
+			reason, _ := conditionMap["reason"].(string)
+			message, _ := conditionMap["message"].(string)
+			reasons = append(reasons, fmt.Sprintf("%s %s=%s (%s): %s", nodePool.Name, conditionType, status, reason, message))
+		}
+	}
+	if len(reasons) == 0 {
+		return scaleErr
+	}
+	log.Errorf("NodePool conditions: %s", strings.Join(reasons, ", "))
+	return fmt.Errorf("%v, nodepool conditions: %s", scaleErr, strings.Join(reasons, ", "))
+}
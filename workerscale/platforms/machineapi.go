@@ -0,0 +1,31 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rosa
+
+import (
+	"context"
+
+	wscale "github.com/vishnuchalla/workers-scale/workerscale"
+	core "github.com/vishnuchalla/workers-scale/workerscale/core"
+)
+
+// orchestrateMachineAPIWorkload runs the machine api scenarios for the given platform
+func orchestrateMachineAPIWorkload(ctx context.Context, scaleConfig wscale.ScaleConfig, platform string) (string, error) {
+	scaleConfig.Platform = platform
+	if scaleConfig.AutoScalerEnabled {
+		return (&core.AutoScalerScenario{}).OrchestrateWorkload(ctx, scaleConfig)
+	}
+	return (&core.BaseScenario{}).OrchestrateWorkload(ctx, scaleConfig)
+}
@@ -0,0 +1,278 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rosa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	wscale "github.com/vishnuchalla/workers-scale/workerscale"
+)
+
+// OCM constants
+const defaultOCMURL = "https://api.openshift.com"
+const defaultOCMTokenURL = "https://sso.redhat.com/auth/realms/redhat-external/protocol/openid-connect/token"
+const defaultOCMClientID = "cloud-services"
+const clustersPath = "/api/clusters_mgmt/v1/clusters"
+const currentAccountPath = "/api/accounts_mgmt/v1/current_account"
+
+// OCMClient manages ROSA clusters through the OCM API
+type OCMClient interface {
+	VerifyLogin(ctx context.Context) error
+	GetClusterID(ctx context.Context, externalID string) (string, error)
+	ListMachinePools(ctx context.Context, clusterID string, isHCP bool) ([]wscale.MachinePool, error)
+	EditMachinePool(ctx context.Context, clusterID string, machinePool wscale.MachinePool, autoScalerEnabled bool, isHCP bool) error
+}
+
+// ocmConfig is the configuration file stored by the ocm and rosa CLIs on login
+type ocmConfig struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ClientID     string `json:"client_id"`
+	TokenURL     string `json:"token_url"`
+	URL          string `json:"url"`
+}
+
+// ocmRESTClient is an OCMClient backed by the OCM REST API
+type ocmRESTClient struct {
+	baseURL      string
+	tokenURL     string
+	clientID     string
+	accessToken  string
+	refreshToken string
+	httpClient   *http.Client
+}
+
+// NewOCMClient creates an OCM client, with the token taken from the environment or the OCM configuration file
+func NewOCMClient() (OCMClient, error) {
+	config := ocmConfig{}
+	configPath := os.Getenv("OCM_CONFIG")
+	if configPath == "" {
+		if configDir, err := os.UserConfigDir(); err == nil {
+			configPath = filepath.Join(configDir, "ocm", "ocm.json")
+		}
+	}
+	if configData, err := os.ReadFile(configPath); err == nil {
+		if err := json.Unmarshal(configData, &config); err != nil {
+			return nil, fmt.Errorf("error parsing OCM configuration %s: %v", configPath, err)
+		}
+	}
+	if token := os.Getenv("OCM_TOKEN"); token != "" {
+		config.AccessToken = token
+	}
+	if token := os.Getenv("ROSA_TOKEN"); token != "" {
+		config.RefreshToken = token
+	}
+	if ocmURL := os.Getenv("OCM_URL"); ocmURL != "" {
+		config.URL = ocmURL
+	}
+	if config.AccessToken == "" && config.RefreshToken == "" {
+		return nil, fmt.Errorf("no OCM token found. Please set OCM_TOKEN or ROSA_TOKEN, or login using 'rosa login'")
+	}
+	return newOCMRESTClient(config), nil
+}
+
+// newOCMRESTClient creates an OCM REST client filling in the default endpoints
+func newOCMRESTClient(config ocmConfig) *ocmRESTClient {
+	ocmClient := &ocmRESTClient{
+		baseURL:      strings.TrimSuffix(config.URL, "/"),
+		tokenURL:     config.TokenURL,
+		clientID:     config.ClientID,
+		accessToken:  config.AccessToken,
+		refreshToken: config.RefreshToken,
+		httpClient:   &http.Client{Timeout: time.Minute},
+	}
+	if ocmClient.baseURL == "" {
+		ocmClient.baseURL = defaultOCMURL
+	}
+	if ocmClient.tokenURL == "" {
+		ocmClient.tokenURL = defaultOCMTokenURL
+	}
+	if ocmClient.clientID == "" {
+		ocmClient.clientID = defaultOCMClientID
+	}
+	return ocmClient
+}
+
+// VerifyLogin verifies that the token is valid for the OCM API
+func (c *ocmRESTClient) VerifyLogin(ctx context.Context) error {
+	var account struct {
+		Username string `json:"username"`
+	}
+	if err := c.do(ctx, http.MethodGet, currentAccountPath, nil, &account); err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetClusterID fetches the OCM cluster ID from the cluster external ID
+func (c *ocmRESTClient) GetClusterID(ctx context.Context, externalID string) (string, error) {
+	var clusterList struct {
+		Items []struct {
+			ID string `json:"id"`
+		} `json:"items"`
+	}
+	query := url.Values{"search": []string{fmt.Sprintf("external_id = '%s'", externalID)}}
+	if err := c.do(ctx, http.MethodGet, clustersPath+"?"+query.Encode(), nil, &clusterList); err != nil {
+		return "", err
+	}
+	if len(clusterList.Items) == 0 {
+		return "", fmt.Errorf("cluster with external ID %s not found", externalID)
+	}
+	return clusterList.Items[0].ID, nil
+}
+
+// ListMachinePools lists the machine pools of a cluster, node pools in case of HCP
+func (c *ocmRESTClient) ListMachinePools(ctx context.Context, clusterID string, isHCP bool) ([]wscale.MachinePool, error) {
+	var machinePoolList struct {
+		Items []wscale.MachinePool `json:"items"`
+	}
+	if err := c.do(ctx, http.MethodGet, machinePoolsPath(clusterID, isHCP), nil, &machinePoolList); err != nil {
+		return nil, err
+	}
+	return machinePoolList.Items, nil
+}
+
+// EditMachinePool sets either the fixed replicas or the autoscaling bounds of a machine pool, switching autoscaling off or on accordingly
+func (c *ocmRESTClient) EditMachinePool(ctx context.Context, clusterID string, machinePool wscale.MachinePool, autoScalerEnabled bool, isHCP bool) error {
+	var patch map[string]interface{}
+	if autoScalerEnabled {
+		autoscaling := map[string]interface{}{
+			"min_replicas": machinePool.Autoscaling.MinReplicas,
+			"max_replicas": machinePool.Autoscaling.MaxReplicas,
+		}
+		if isHCP {
+			autoscaling = map[string]interface{}{
+				"min_replica": machinePool.Autoscaling.MinReplica,
+				"max_replica": machinePool.Autoscaling.MaxReplica,
+			}
+		}
+		patch = map[string]interface{}{"autoscaling": autoscaling}
+	} else {
+		// A null autoscaling turns autoscaling off on pools that had it enabled
+		patch = map[string]interface{}{"autoscaling": nil, "replicas": machinePool.Replicas}
+	}
+	return c.do(ctx, http.MethodPatch, machinePoolsPath(clusterID, isHCP)+"/"+machinePool.ID, patch, nil)
+}
+
+// machinePoolsPath returns the machine pools path of a cluster
+func machinePoolsPath(clusterID string, isHCP bool) string {
+	if isHCP {
+		return fmt.Sprintf("%s/%s/node_pools", clustersPath, clusterID)
+	}
+	return fmt.Sprintf("%s/%s/machine_pools", clustersPath, clusterID)
+}
+
+// do sends a request to the OCM API, decoding the response into result when given
+func (c *ocmRESTClient) do(ctx context.Context, method string, path string, body interface{}, result interface{}) error {
+	if c.accessToken == "" {
+		if err := c.refreshAccessToken(ctx); err != nil {
+			return err
+		}
+	}
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+	resp, err := c.send(ctx, method, path, payload)
+	if err != nil {
+		return err
+	}
+	// Access tokens are short lived, refresh once and retry when possible
+	if resp.StatusCode == http.StatusUnauthorized && c.refreshToken != "" {
+		resp.Body.Close()
+		if err := c.refreshAccessToken(ctx); err != nil {
+			return err
+		}
+		if resp, err = c.send(ctx, method, path, payload); err != nil {
+			return err
+		}
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading OCM response: %v", err)
+	}
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("OCM request %s %s failed with status %d: %s", method, path, resp.StatusCode, string(respBody))
+	}
+	if result != nil {
+		if err := json.Unmarshal(respBody, result); err != nil {
+			return fmt.Errorf("error parsing OCM response: %v", err)
+		}
+	}
+	return nil
+}
+
+// send sends an authenticated request to the OCM API
+func (c *ocmRESTClient) send(ctx context.Context, method string, path string, payload []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.accessToken)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending OCM request %s %s: %v", method, path, err)
+	}
+	return resp, nil
+}
+
+// refreshAccessToken exchanges the offline token for a new access token
+func (c *ocmRESTClient) refreshAccessToken(ctx context.Context) error {
+	if c.refreshToken == "" {
+		return fmt.Errorf("OCM access token expired and no offline token available")
+	}
+	form := url.Values{
+		"grant_type":    []string{"refresh_token"},
+		"client_id":     []string{c.clientID},
+		"refresh_token": []string{c.refreshToken},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error refreshing OCM token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("error refreshing OCM token, status %d: %s", resp.StatusCode, string(respBody))
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("error parsing OCM token response: %v", err)
+	}
+	c.accessToken = token.AccessToken
+	return nil
+}
@@ -0,0 +1,250 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package rosa
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	wscale "github.com/vishnuchalla/workers-scale/workerscale"
+)
+
+// newTestOCMServer serves the OCM API and token endpoints, handing out fresh-token once refreshed
+func newTestOCMServer(t *testing.T, handler http.HandlerFunc) (*httptest.Server, *int) {
+	t.Helper()
+	refreshes := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("error parsing token request: %v", err)
+		}
+		if r.Form.Get("grant_type") != "refresh_token" || r.Form.Get("refresh_token") != "offline-token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		refreshes++
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"fresh-token"}`))
+	})
+	mux.HandleFunc("/", handler)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server, &refreshes
+}
+
+func TestListMachinePools(t *testing.T) {
+	tests := []struct {
+		name  string
+		isHCP bool
+		path  string
+	}{
+		{name: "classic", path: "/api/clusters_mgmt/v1/clusters/cluster-id/machine_pools"},
+		{name: "hcp", isHCP: true, path: "/api/clusters_mgmt/v1/clusters/cluster-id/node_pools"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := newTestOCMServer(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodGet || r.URL.Path != tt.path {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				if got := r.Header.Get("Authorization"); got != "Bearer access-token" {
+					t.Errorf("unexpected authorization header %q", got)
+				}
+				_, _ = w.Write([]byte(`{"items":[{"id":"workers","replicas":2},{"id":"autoscaled","autoscaling":{"min_replicas":1,"max_replicas":3,"min_replica":1,"max_replica":3}}]}`))
+			})
+			ocmClient := newOCMRESTClient(ocmConfig{URL: server.URL, AccessToken: "access-token"})
+			machinePools, err := ocmClient.ListMachinePools(context.Background(), "cluster-id", tt.isHCP)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expected := []wscale.MachinePool{
+				{ID: "workers", Replicas: 2},
+				{ID: "autoscaled", Autoscaling: wscale.Autoscaling{MinReplicas: 1, MaxReplicas: 3, MinReplica: 1, MaxReplica: 3}},
+			}
+			if !reflect.DeepEqual(machinePools, expected) {
+				t.Errorf("got %+v, expected %+v", machinePools, expected)
+			}
+		})
+	}
+}
+
+func TestEditMachinePool(t *testing.T) {
+	tests := []struct {
+		name              string
+		machinePool       wscale.MachinePool
+		autoScalerEnabled bool
+		isHCP             bool
+		path              string
+		body              string
+	}{
+		{
+			name:        "fixed replicas disable autoscaling",
+			machinePool: wscale.MachinePool{ID: "workers", Replicas: 3},
+			path:        "/api/clusters_mgmt/v1/clusters/cluster-id/machine_pools/workers",
+			body:        `{"autoscaling":null,"replicas":3}`,
+		},
+		{
+			name:              "classic autoscaling bounds",
+			machinePool:       wscale.MachinePool{ID: "workers", Autoscaling: wscale.Autoscaling{MinReplicas: 1, MaxReplicas: 4}},
+			autoScalerEnabled: true,
+			path:              "/api/clusters_mgmt/v1/clusters/cluster-id/machine_pools/workers",
+			body:              `{"autoscaling":{"min_replicas":1,"max_replicas":4}}`,
+		},
+		{
+			name:              "hcp autoscaling bounds",
+			machinePool:       wscale.MachinePool{ID: "workers", Autoscaling: wscale.Autoscaling{MinReplica: 2, MaxReplica: 5}},
+			autoScalerEnabled: true,
+			isHCP:             true,
+			path:              "/api/clusters_mgmt/v1/clusters/cluster-id/node_pools/workers",
+			body:              `{"autoscaling":{"min_replica":2,"max_replica":5}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body []byte
+			server, _ := newTestOCMServer(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPatch || r.URL.Path != tt.path {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				body, _ = io.ReadAll(r.Body)
+				_, _ = w.Write([]byte(`{}`))
+			})
+			ocmClient := newOCMRESTClient(ocmConfig{URL: server.URL, AccessToken: "access-token"})
+			if err := ocmClient.EditMachinePool(context.Background(), "cluster-id", tt.machinePool, tt.autoScalerEnabled, tt.isHCP); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var got, expected interface{}
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatalf("error parsing request body %s: %v", body, err)
+			}
+			_ = json.Unmarshal([]byte(tt.body), &expected)
+			if !reflect.DeepEqual(got, expected) {
+				t.Errorf("got body %s, expected %s", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestTokenRefresh(t *testing.T) {
+	tests := []struct {
+		name        string
+		accessToken string
+		refreshes   int
+	}{
+		{name: "missing access token", refreshes: 1},
+		{name: "expired access token", accessToken: "expired-token", refreshes: 1},
+		{name: "valid access token", accessToken: "fresh-token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, refreshes := newTestOCMServer(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "Bearer fresh-token" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				_, _ = w.Write([]byte(`{"username":"user"}`))
+			})
+			ocmClient := newOCMRESTClient(ocmConfig{URL: server.URL, TokenURL: server.URL + "/token", AccessToken: tt.accessToken, RefreshToken: "offline-token"})
+			if err := ocmClient.VerifyLogin(context.Background()); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if *refreshes != tt.refreshes {
+				t.Errorf("got %d token refreshes, expected %d", *refreshes, tt.refreshes)
+			}
+		})
+	}
+}
+
+func TestTokenRefreshWithoutOfflineToken(t *testing.T) {
+	server, refreshes := newTestOCMServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	ocmClient := newOCMRESTClient(ocmConfig{URL: server.URL, TokenURL: server.URL + "/token", AccessToken: "expired-token"})
+	err := ocmClient.VerifyLogin(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "status 401") {
+		t.Errorf("expected a 401 error, got %v", err)
+	}
+	if *refreshes != 0 {
+		t.Errorf("got %d token refreshes, expected none", *refreshes)
+	}
+}
+
+func TestNon2xxResponses(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+	}{
+		{name: "bad request", status: http.StatusBadRequest},
+		{name: "not found", status: http.StatusNotFound},
+		{name: "server error", status: http.StatusInternalServerError},
+		{name: "redirect", status: http.StatusMultipleChoices},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := newTestOCMServer(t, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(`{"reason":"failure"}`))
+			})
+			ocmClient := newOCMRESTClient(ocmConfig{URL: server.URL, AccessToken: "access-token"})
+			if _, err := ocmClient.ListMachinePools(context.Background(), "cluster-id", false); err == nil || !strings.Contains(err.Error(), "failure") {
+				t.Errorf("expected an error carrying the response, got %v", err)
+			}
+			if err := ocmClient.EditMachinePool(context.Background(), "cluster-id", wscale.MachinePool{ID: "workers", Replicas: 1}, false, false); err == nil {
+				t.Error("expected an error editing the machinepool")
+			}
+			if _, err := ocmClient.GetClusterID(context.Background(), "external-id"); err == nil {
+				t.Error("expected an error getting the cluster ID")
+			}
+		})
+	}
+}
+
+func TestGetClusterID(t *testing.T) {
+	server, _ := newTestOCMServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("search"); got != "external_id = 'external-id'" {
+			t.Errorf("unexpected search %q", got)
+		}
+		_, _ = w.Write([]byte(`{"items":[{"id":"cluster-id"}]}`))
+	})
+	ocmClient := newOCMRESTClient(ocmConfig{URL: server.URL, AccessToken: "access-token"})
+	clusterID, err := ocmClient.GetClusterID(context.Background(), "external-id")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if clusterID != "cluster-id" {
+		t.Errorf("got cluster ID %s, expected cluster-id", clusterID)
+	}
+}
+
+func TestCancelledContext(t *testing.T) {
+	server, refreshes := newTestOCMServer(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+	})
+	ocmClient := newOCMRESTClient(ocmConfig{URL: server.URL, TokenURL: server.URL + "/token", RefreshToken: "offline-token"})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ocmClient.ListMachinePools(ctx, "cluster-id", false); err == nil {
+		t.Error("expected an error with a cancelled context")
+	}
+	if *refreshes != 0 {
+		t.Errorf("got %d token refreshes, expected none", *refreshes)
+	}
+}
@@ -16,10 +16,8 @@ package rosa
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"os/exec"
-	"strings"
 	"sync"
 	"time"
 
@@ -33,100 +31,174 @@ import (
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-type RosaScenario struct{}
+type RosaScenario struct {
+	OCMClient OCMClient
+}
 
-func (rosaScenario *RosaScenario) OrchestrateWorkload(scaleConfig wscale.ScaleConfig) string {
+func (rosaScenario *RosaScenario) OrchestrateWorkload(ctx context.Context, scaleConfig wscale.ScaleConfig) (string, error) {
 	var err error
 	var triggerJob string
 	var clusterID string
 	var hcNamespace string
 	var machineClient interface{}
+	var mcClientSet kubernetes.Interface
 	var triggerTime time.Time
 
 	kubeClientProvider := config.NewKubeClientProvider("", "")
 	clientSet, restConfig := kubeClientProvider.ClientSet(0, 0)
 	dynamicClient := dynamic.NewForConfigOrDie(restConfig)
-	clusterID = getClusterID(dynamicClient, scaleConfig.IsHCP)
+	editMachinePools := scaleConfig.ScaleEventEpoch == 0 || scaleConfig.AutoScalerEnabled
+	// Classic clusters only need OCM to edit machinepools, HCP machines are also looked up by the OCM cluster ID
+	if editMachinePools || scaleConfig.IsHCP {
+		if rosaScenario.OCMClient == nil {
+			if rosaScenario.OCMClient, err = NewOCMClient(); err != nil {
+				return "", fmt.Errorf("error creating OCM client: %v", err)
+			}
+		}
+		if clusterID, err = getClusterID(ctx, dynamicClient, rosaScenario.OCMClient); err != nil {
+			return "", err
+		}
+	}
 	if scaleConfig.IsHCP {
 		if scaleConfig.MCKubeConfig == "" {
-			log.Fatal("Error reading management cluster kubeconfig. Please provide a valid path")
+			return "", fmt.Errorf("error reading management cluster kubeconfig. Please provide a valid path")
 		}
 		mcKubeClientProvider := config.NewKubeClientProvider(scaleConfig.MCKubeConfig, "")
-		mcClientSet, mcRestConfig := mcKubeClientProvider.ClientSet(0, 0)
-		machineClient = wscale.GetCAPIClient(mcRestConfig)
-		hcNamespace = wscale.GetHCNamespace(mcClientSet, clusterID)
+		var mcRestConfig *rest.Config
+		mcClientSet, mcRestConfig = mcKubeClientProvider.ClientSet(0, 0)
+		if machineClient, err = wscale.GetCAPIClient(mcRestConfig); err != nil {
+			return "", err
+		}
+		if hcNamespace, err = wscale.GetHCNamespace(ctx, mcClientSet, clusterID); err != nil {
+			return "", err
+		}
 	} else {
-		machineClient = wscale.GetMachineClient(restConfig)
+		if machineClient, err = wscale.GetMachineClient(restConfig); err != nil {
+			return "", err
+		}
 	}
 
-	if scaleConfig.ScaleEventEpoch != 0 && !scaleConfig.AutoScalerEnabled {
+	if !editMachinePools {
 		log.Info("Scale event epoch time specified. Hence calculating node latencies without any scaling")
 		wscale.SetupMetrics(scaleConfig.UUID, scaleConfig.Metadata, kubeClientProvider)
 		measurements.Start()
-		if err = wscale.WaitForNodes(clientSet); err != nil {
-			log.Fatalf("Error waiting for nodes: %v", err)
+		scaleUpCtx, cancel := context.WithTimeout(ctx, scaleConfig.ScaleUpTimeout)
+		defer cancel()
+		if err = wscale.WaitForNodes(scaleUpCtx, clientSet); err != nil {
+			return "", checkFailedMachines(ctx, machineClient, clientSet, mcClientSet, clusterID, hcNamespace, time.Unix(scaleConfig.ScaleEventEpoch, 0).UTC(), scaleConfig, fmt.Errorf("error waiting for nodes: %v", err))
+		}
+		scaledMachineDetails, amiID, err := getMachineDetails(ctx, machineClient, scaleConfig.ScaleEventEpoch, clusterID, hcNamespace, scaleConfig.IsHCP)
+		if err != nil {
+			return "", err
+		}
+		if err = wscale.GetLifecycleTimelines(ctx, clientSet, scaledMachineDetails); err != nil {
+			return "", err
 		}
-		scaledMachineDetails, amiID := getMachineDetails(machineClient, scaleConfig.ScaleEventEpoch, clusterID, hcNamespace, scaleConfig.IsHCP)
 		if err := measurements.Stop(); err != nil {
-			log.Fatal(err.Error())
+			return "", err
 		}
-		wscale.FinalizeMetrics(&sync.Map{}, scaledMachineDetails, scaleConfig.Metadata, scaleConfig.Indexer, amiID, scaleConfig.ScaleEventEpoch)
-		return amiID
+		wscale.FinalizeMetrics(&sync.Map{}, scaledMachineDetails, scaleConfig.Metadata, scaleConfig.Indexer, scaleConfig.ScaleEventEpoch, scaleConfig.Iteration)
+		return amiID, nil
 	} else {
-		verifyRosaInstall()
-		var machinePools []wscale.MachinePool
-		cmd := exec.Command("rosa", "list", "machinepool", "--cluster", clusterID, "--output", "json")
-		mpOutput, err := cmd.CombinedOutput()
+		if err = verifyRosaLogin(ctx, rosaScenario.OCMClient); err != nil {
+			return "", err
+		}
+		machinePools, err := rosaScenario.OCMClient.ListMachinePools(ctx, clusterID, scaleConfig.IsHCP)
 		if err != nil {
-			log.Fatalf("Unable to list machinepools: %v. Output: %s", err, string(mpOutput))
+			return "", fmt.Errorf("unable to list machinepools: %v", err)
 		}
-		err = json.Unmarshal(mpOutput, &machinePools)
+		prevMachineDetails, _, err := getMachineDetails(ctx, machineClient, 0, clusterID, hcNamespace, scaleConfig.IsHCP)
 		if err != nil {
-			log.Fatalf("Error parsing machinepool JSON output: %v\n", err)
+			return "", err
 		}
-		prevMachineDetails, _ := getMachineDetails(machineClient, 0, clusterID, hcNamespace, scaleConfig.IsHCP)
 		wscale.SetupMetrics(scaleConfig.UUID, scaleConfig.Metadata, kubeClientProvider)
 		measurements.Start()
 
-		triggerTime = editMachinepool(clusterID, machinePools, scaleConfig.AdditionalWorkerNodes, scaleConfig.AutoScalerEnabled, scaleConfig.IsHCP)
+		wscale.RecordMachinePools(clusterID, scaleConfig.IsHCP, machinePools)
+		wscale.RegisterRollback(wscale.MachinePoolsRollback, func() error {
+			return restoreMachinePools(context.Background(), rosaScenario.OCMClient, clusterID, machinePools, scaleConfig.IsHCP)
+		})
+		podProbe, err := wscale.StartPodProbe(ctx, clientSet, scaleConfig.ProbeImage)
+		if err != nil {
+			return "", err
+		}
+		defer podProbe.Cancel()
+		scaleUpCtx, cancelScaleUp := context.WithTimeout(ctx, scaleConfig.ScaleUpTimeout)
+		defer cancelScaleUp()
+		scaleUpTimestamp := time.Now().UTC().Truncate(time.Second)
+		triggerTime, err = editMachinepool(scaleUpCtx, rosaScenario.OCMClient, clusterID, machinePools, scaleConfig.AdditionalWorkerNodes, scaleConfig.AutoScalerEnabled, scaleConfig.IsHCP)
+		if err != nil {
+			return "", err
+		}
 		if scaleConfig.AutoScalerEnabled {
-			triggerJob, triggerTime = core.CreateBatchJob(clientSet)
+			if triggerJob, triggerTime, err = core.CreateBatchJob(scaleUpCtx, clientSet); err != nil {
+				return "", err
+			}
+			wscale.RegisterRollback(wscale.BatchJobRollback, func() error {
+				return core.DeleteBatchJob(context.Background(), clientSet, triggerJob)
+			})
 			// Slightly more delay for the cluster autoscaler resources to come up
-			time.Sleep(5 * time.Minute)
+			if err = wscale.Sleep(scaleUpCtx, 5*time.Minute); err != nil {
+				return "", err
+			}
 		}
 		log.Info("Waiting for the machinesets to be ready")
-		if err = waitForWorkers(machineClient, clusterID, hcNamespace, scaleConfig.IsHCP); err != nil {
-			log.Fatalf("Error waiting for MachineSets to be ready: %v", err)
+		if err = waitForWorkers(scaleUpCtx, machineClient, clusterID, hcNamespace, scaleConfig.IsHCP); err != nil {
+			return "", checkFailedMachines(ctx, machineClient, clientSet, mcClientSet, clusterID, hcNamespace, scaleUpTimestamp, scaleConfig, fmt.Errorf("error waiting for MachineSets to be ready: %v", err))
+		}
+		scaledMachineDetails, amiID, err := getMachineDetails(ctx, machineClient, 0, clusterID, hcNamespace, scaleConfig.IsHCP)
+		if err != nil {
+			return "", err
 		}
-		scaledMachineDetails, amiID := getMachineDetails(machineClient, 0, clusterID, hcNamespace, scaleConfig.IsHCP)
 		wscale.DiscardPreviousMachines(prevMachineDetails, scaledMachineDetails)
+		if err = wscale.GetLifecycleTimelines(ctx, clientSet, scaledMachineDetails); err != nil {
+			return "", err
+		}
 		if err := measurements.Stop(); err != nil {
-			log.Fatal(err.Error())
+			return "", err
 		}
-		wscale.FinalizeMetrics(&sync.Map{}, scaledMachineDetails, scaleConfig.Metadata, scaleConfig.Indexer, amiID, triggerTime.Unix())
+		probeResults := podProbe.Stop(ctx)
+		wscale.FinalizeMetrics(&sync.Map{}, scaledMachineDetails, scaleConfig.Metadata, scaleConfig.Indexer, triggerTime.Unix(), scaleConfig.Iteration)
+		wscale.FinalizePodProbeMetrics(scaleConfig.UUID, scaleConfig.ProbeImage, probeResults, scaleConfig.Metadata, scaleConfig.Indexer, scaleConfig.Iteration)
 		if scaleConfig.AutoScalerEnabled {
-			core.DeleteBatchJob(clientSet, triggerJob)
-			time.Sleep(1 * time.Minute)
+			if err = core.DeleteBatchJob(ctx, clientSet, triggerJob); err != nil {
+				return "", err
+			}
+			wscale.UnregisterRollback(wscale.BatchJobRollback)
+			if err = wscale.Sleep(ctx, 1*time.Minute); err != nil {
+				return "", err
+			}
 		}
 		if scaleConfig.GC {
 			log.Info("Restoring machine pool to previous state")
-			editMachinepool(clusterID, machinePools, 0, scaleConfig.AutoScalerEnabled, scaleConfig.IsHCP)
+			scaleDownCtx, cancelScaleDown := context.WithTimeout(ctx, scaleConfig.ScaleDownTimeout)
+			defer cancelScaleDown()
+			if err = restoreMachinePools(scaleDownCtx, rosaScenario.OCMClient, clusterID, machinePools, scaleConfig.IsHCP); err != nil {
+				return "", fmt.Errorf("failed to restore machinepools: %v", err)
+			}
+			if err = wscale.Sleep(scaleDownCtx, 30*time.Second); err != nil {
+				return "", err
+			}
 			log.Info("Waiting for the machinesets to scale down")
-			if err = waitForWorkers(machineClient, clusterID, hcNamespace, scaleConfig.IsHCP); err != nil {
-				log.Fatalf("Error waiting for MachineSets to scale down: %v", err)
+			if err = waitForWorkers(scaleDownCtx, machineClient, clusterID, hcNamespace, scaleConfig.IsHCP); err != nil {
+				return "", fmt.Errorf("error waiting for MachineSets to scale down: %v", err)
 			}
 		}
-		return amiID
+		wscale.UnregisterRollback(wscale.MachinePoolsRollback)
+		wscale.CompleteRunState(scaleConfig.GC)
+		return amiID, nil
 	}
 }
 
 // editMachinepool edits machinepool to desired replica count
-func editMachinepool(clusterID string, machinePools []wscale.MachinePool, additionalWorkerNodes int, autoScalerEnabled bool, isHCP bool) time.Time {
+func editMachinepool(ctx context.Context, ocmClient OCMClient, clusterID string, machinePools []wscale.MachinePool, additionalWorkerNodes int, autoScalerEnabled bool, isHCP bool) (time.Time, error) {
 	if len(machinePools) == 0 {
-		log.Fatal("No machinepool found. Aborting execution")
+		return time.Time{}, fmt.Errorf("no machinepool found. Aborting execution")
 	}
 	quotient := additionalWorkerNodes / len(machinePools)
 	remainder := additionalWorkerNodes % len(machinePools)
@@ -148,96 +220,118 @@ func editMachinepool(clusterID string, machinePools []wscale.MachinePool, additi
 			minReplicas = machinePool.Replicas
 			maxReplicas = machinePool.Replicas
 		}
-		cmdArgs := []string{"edit", "machinepool", "-c", clusterID, machinePool.ID, fmt.Sprintf("--enable-autoscaling=%t", autoScalerEnabled)}
-		if autoScalerEnabled {
-			cmdArgs = append(cmdArgs, fmt.Sprintf("--min-replicas=%d", minReplicas))
-			cmdArgs = append(cmdArgs, fmt.Sprintf("--max-replicas=%d", maxReplicas+quotient+(remainder&1)))
-		} else {
-			cmdArgs = append(cmdArgs, fmt.Sprintf("--replicas=%d", maxReplicas+quotient+(remainder&1)))
+		desiredMachinePool := wscale.MachinePool{
+			ID:       machinePool.ID,
+			Replicas: maxReplicas + quotient + (remainder & 1),
+			Autoscaling: wscale.Autoscaling{
+				MinReplicas: minReplicas,
+				MaxReplicas: maxReplicas + quotient + (remainder & 1),
+				MinReplica:  minReplicas,
+				MaxReplica:  maxReplicas + quotient + (remainder & 1),
+			},
 		}
-		cmd := exec.Command("bash", "-c", "rosa"+" "+strings.Join(cmdArgs, " "))
-		editOutput, err := cmd.CombinedOutput()
-		if err != nil {
-			log.Fatalf("Failed to edit machinepool: %v. Output: %s", err, string(editOutput))
+		if err := ocmClient.EditMachinePool(ctx, clusterID, desiredMachinePool, autoScalerEnabled, isHCP); err != nil {
+			return time.Time{}, fmt.Errorf("failed to edit machinepool: %v", err)
 		}
 		log.Infof("Machinepool %v edited successfully on cluster: %v", machinePool.ID, clusterID)
-		log.Debug(string(editOutput))
 		if remainder > 0 {
 			remainder--
 		}
 	}
 	triggerTime := time.Now().UTC().Truncate(time.Second)
-	time.Sleep(30 * time.Second)
-	return triggerTime
+	if err := wscale.Sleep(ctx, 30*time.Second); err != nil {
+		return time.Time{}, err
+	}
+	return triggerTime, nil
 }
 
-// verifyRosaInstall verifies rosa installation and login
-func verifyRosaInstall() {
-	if _, err := exec.LookPath("rosa"); err != nil {
-		log.Fatal("ROSA CLI is not installed. Please install it and retry.")
-		return
+// restoreMachinePools sets the machinepools back to their original replicas, enabling or disabling autoscaling as it was
+func restoreMachinePools(ctx context.Context, ocmClient OCMClient, clusterID string, machinePools []wscale.MachinePool, isHCP bool) error {
+	var errs []error
+	for _, machinePool := range machinePools {
+		// Machinepools without a fixed replica count were autoscaled
+		autoScaled := machinePool.Replicas == 0 && (machinePool.Autoscaling.MaxReplicas > 0 || machinePool.Autoscaling.MaxReplica > 0)
+		if err := ocmClient.EditMachinePool(ctx, clusterID, machinePool, autoScaled, isHCP); err != nil {
+			errs = append(errs, fmt.Errorf("failed to restore machinepool %s: %v", machinePool.ID, err))
+			continue
+		}
+		log.Infof("Machinepool %v restored on cluster: %v", machinePool.ID, clusterID)
 	}
-	log.Info("ROSA CLI is installed.")
+	return errors.Join(errs...)
+}
 
-	cmd := exec.Command("bash", "-c", "rosa whoami")
-	output, err := cmd.CombinedOutput()
+// RestoreMachinePools restores the machinepools recorded in the state of a previous run
+func RestoreMachinePools(ctx context.Context, runState wscale.RunState) error {
+	ocmClient, err := NewOCMClient()
 	if err != nil {
-		log.Fatalf("You are not logged in. Please login using 'rosa login' and retry: %v. Output: %s", err, string(output))
+		return fmt.Errorf("error creating OCM client: %v", err)
+	}
+	return restoreMachinePools(ctx, ocmClient, runState.ClusterID, runState.MachinePools, runState.IsHCP)
+}
+
+// verifyRosaLogin verifies the OCM token is valid
+func verifyRosaLogin(ctx context.Context, ocmClient OCMClient) error {
+	if err := ocmClient.VerifyLogin(ctx); err != nil {
+		return fmt.Errorf("you are not logged in. Please login using 'rosa login' or set OCM_TOKEN and retry: %v", err)
 	}
 	log.Info("You are already logged in.")
-	log.Debug(string(output))
+	return nil
 }
 
-// getClusterID fetches the clusterID
-func getClusterID(dynamicClient dynamic.Interface, mcPrescence bool) string {
+// getClusterID fetches the OCM clusterID
+func getClusterID(ctx context.Context, dynamicClient dynamic.Interface, ocmClient OCMClient) (string, error) {
+	externalID, err := getExternalClusterID(ctx, dynamicClient)
+	if err != nil {
+		return "", err
+	}
+	// Cluster version object has the external ID, whereas OCM and hcp resources use the internal ID
+	clusterID, err := ocmClient.GetClusterID(ctx, externalID)
+	if err != nil {
+		return "", fmt.Errorf("failed to describe cluster: %v", err)
+	}
+	return clusterID, nil
+}
+
+// getExternalClusterID fetches the clusterID from the cluster version object
+func getExternalClusterID(ctx context.Context, dynamicClient dynamic.Interface) (string, error) {
 	clusterVersionGVR := schema.GroupVersionResource{
 		Group:    "config.openshift.io",
 		Version:  "v1",
 		Resource: "clusterversions",
 	}
 
-	clusterVersion, err := dynamicClient.Resource(clusterVersionGVR).Get(context.TODO(), "version", metav1.GetOptions{})
+	clusterVersion, err := dynamicClient.Resource(clusterVersionGVR).Get(ctx, "version", metav1.GetOptions{})
 	if err != nil {
-		log.Fatalf("Error fetching cluster version: %v", err)
+		return "", fmt.Errorf("error fetching cluster version: %v", err)
 	}
 
-	clusterID, found, err := unstructured.NestedString(clusterVersion.Object, "spec", "clusterID")
+	externalID, found, err := unstructured.NestedString(clusterVersion.Object, "spec", "clusterID")
 	if err != nil || !found {
-		log.Fatalf("Error retrieving cluster ID: %v", err)
-	}
-
-	// Special case for hcp where cluster version object has external ID
-	if mcPrescence {
-		cmd := exec.Command("rosa", "describe", "cluster", "-c", clusterID, "-o", "json")
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			log.Fatalf("Failed to describe cluster: %v. Output: %s", err, string(output))
-		}
-		var result map[string]interface{}
-		if err := json.Unmarshal(output, &result); err != nil {
-			log.Fatalf("Failed to parse JSON: %v", err)
-		}
-		actualID, ok := result["id"].(string)
-		if !ok {
-			log.Fatal("ID field not found or invalid in the output.")
-		}
-		return actualID
+		return "", fmt.Errorf("error retrieving cluster ID: %v", err)
 	}
-	return clusterID
+	return externalID, nil
 }
 
 // Function to fetch machine details based on the scenario (standard Rosa or RosaHCP).
-func getMachineDetails(machineClient interface{}, epoch int64, clusterID string, hcNamespace string, isHCP bool) (map[string]wscale.MachineInfo, string) {
+func getMachineDetails(ctx context.Context, machineClient interface{}, epoch int64, clusterID string, hcNamespace string, isHCP bool) (map[string]wscale.MachineInfo, string, error) {
 	if isHCP {
-		return wscale.GetCapiMachines(machineClient.(client.Client), epoch, clusterID, hcNamespace)
+		return wscale.GetCapiMachines(ctx, machineClient.(client.Client), epoch, clusterID, hcNamespace, nil)
 	}
-	return wscale.GetMachines(machineClient.(*machinev1beta1.MachineV1beta1Client), epoch)
+	return wscale.GetMachines(ctx, machineClient.(*machinev1beta1.MachineV1beta1Client), epoch, wscale.AWSPlatform, nil)
 }
 
 // Function to wait for worker MachineSets based on the scenario (standard Rosa or RosaHCP).
-func waitForWorkers(machineClient interface{}, clusterID string, hcNamespace string, isHCP bool) error {
+func waitForWorkers(ctx context.Context, machineClient interface{}, clusterID string, hcNamespace string, isHCP bool) error {
 	if isHCP {
-		return wscale.WaitForCAPIMachineSets(machineClient.(client.Client), clusterID, hcNamespace)
+		return wscale.WaitForCAPIMachineSets(ctx, machineClient.(client.WithWatch), clusterID, hcNamespace, nil)
+	}
+	return wscale.WaitForWorkerMachineSets(ctx, machineClient.(*machinev1beta1.MachineV1beta1Client), nil)
+}
+
+// Function to check the machines which failed to run based on the scenario (standard Rosa or RosaHCP).
+func checkFailedMachines(ctx context.Context, machineClient interface{}, clientSet kubernetes.Interface, mcClientSet kubernetes.Interface, clusterID string, hcNamespace string, scaleUpTimestamp time.Time, scaleConfig wscale.ScaleConfig, scaleErr error) error {
+	if scaleConfig.IsHCP {
+		return wscale.CheckFailedCAPIMachines(ctx, machineClient.(client.Client), mcClientSet, clusterID, hcNamespace, scaleUpTimestamp, scaleConfig, scaleErr)
 	}
-	return wscale.WaitForWorkerMachineSets(machineClient.(*machinev1beta1.MachineV1beta1Client))
+	return wscale.CheckFailedMachines(ctx, machineClient.(*machinev1beta1.MachineV1beta1Client), clientSet, scaleUpTimestamp, scaleConfig, scaleErr)
 }
@@ -0,0 +1,322 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerscale
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/cloud-bulldozer/go-commons/indexers"
+	"github.com/kube-burner/kube-burner/pkg/measurements"
+	mmetrics "github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	mtypes "github.com/kube-burner/kube-burner/pkg/measurements/types"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+)
+
+// PodProbe schedules a pod on every scaled node once it turns ready, to measure when workloads can run on the new capacity
+type PodProbe struct {
+	clientSet kubernetes.Interface
+	image     string
+	watcher   *ClusterWatcher
+	release   func()
+	cancel    context.CancelFunc
+	cancelled sync.Once
+	doneCh    chan struct{}
+	probes    map[string]PodProbeInfo
+}
+
+// StartPodProbe starts probing the nodes turning ready, nothing is probed without an image
+func StartPodProbe(ctx context.Context, clientSet kubernetes.Interface, image string) (*PodProbe, error) {
+	if image == "" {
+		return nil, nil
+	}
+	watcher, release, err := acquireWatcher(ctx, nil, clientSet)
+	if err != nil {
+		return nil, err
+	}
+	probeCtx, cancel := context.WithCancel(ctx)
+	podProbe := &PodProbe{
+		clientSet: clientSet,
+		image:     image,
+		watcher:   watcher,
+		release:   release,
+		cancel:    cancel,
+		doneCh:    make(chan struct{}),
+		probes:    make(map[string]PodProbeInfo),
+	}
+	RegisterRollback(PodProbeRollback, func() error {
+		return DeleteProbePods(context.Background(), clientSet)
+	})
+	go podProbe.run(probeCtx)
+	return podProbe, nil
+}
+
+// run creates the probe pods as the scaled nodes turn ready
+func (p *PodProbe) run(ctx context.Context) {
+	defer close(p.doneCh)
+	for {
+		var readyNodes []*corev1.Node
+		err := p.watcher.WaitFor(ctx, func() bool {
+			readyNodes = p.newReadyNodes()
+			return len(readyNodes) > 0
+		})
+		if err != nil {
+			return
+		}
+		for _, node := range readyNodes {
+			p.createProbePod(ctx, node)
+		}
+	}
+}
+
+// newReadyNodes returns the scaled nodes which turned ready since the watcher started and are not probed yet.
+// When machines are watched, only the nodes of the machines created by the run are probed
+func (p *PodProbe) newReadyNodes() []*corev1.Node {
+	var readyNodes []*corev1.Node
+	scaledNodes := p.watcher.scaledNodes()
+	for _, node := range p.watcher.Nodes() {
+		if _, probed := p.probes[probePodName(node.Name)]; probed {
+			continue
+		}
+		if scaledNodes != nil && !scaledNodes[node.Name] {
+			continue
+		}
+		nodeEvents := p.watcher.NodeEvents(node.Name)
+		_, created := nodeEvents[nodeCreatedEvent]
+		if _, ready := nodeEvents[nodeReadyEvent]; !created || !ready {
+			continue
+		}
+		readyNodes = append(readyNodes, node)
+	}
+	return readyNodes
+}
+
+// createProbePod creates a pod selecting the given node
+func (p *PodProbe) createProbePod(ctx context.Context, node *corev1.Node) {
+	podName := probePodName(node.Name)
+	p.probes[podName] = PodProbeInfo{
+		nodeName:           node.Name,
+		nodeReadyTimestamp: p.watcher.NodeEvents(node.Name)[nodeReadyEvent],
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: podName,
+			Labels: map[string]string{
+				"app": probePodLabel,
+			},
+		},
+		Spec: corev1.PodSpec{
+			// Goes through the scheduler, unlike setting the nodeName
+			NodeSelector: map[string]string{
+				corev1.LabelHostname: node.Labels[corev1.LabelHostname],
+			},
+			// Scaled nodes may be tainted, like the ones of ephemeral machinesets
+			Tolerations: []corev1.Toleration{
+				{
+					Operator: corev1.TolerationOpExists,
+				},
+			},
+			Containers: []corev1.Container{
+				{
+					Name:  "probe",
+					Image: p.image,
+				},
+			},
+			RestartPolicy:                 corev1.RestartPolicyNever,
+			TerminationGracePeriodSeconds: Int64Ptr(0),
+		},
+	}
+	if _, err := p.clientSet.CoreV1().Pods(DefaultNamespace).Create(ctx, pod, metav1.CreateOptions{}); err != nil {
+		log.Errorf("Error creating probe pod on node %s: %v", node.Name, err)
+		return
+	}
+	RecordCreatedResource(PodKind, podName)
+	log.Debugf("Probe pod %s created on node %s", podName, node.Name)
+}
+
+// probePodName returns the name of the probe pod of a node
+func probePodName(nodeName string) string {
+	return fmt.Sprintf("%s-probe-%s", JobName, nodeName)
+}
+
+// Cancel stops probing new nodes
+func (p *PodProbe) Cancel() {
+	if p == nil {
+		return
+	}
+	p.cancelled.Do(func() {
+		p.cancel()
+		<-p.doneCh
+		p.release()
+	})
+}
+
+// Stop waits for the probe pods to run, returns their details and deletes them
+func (p *PodProbe) Stop(ctx context.Context) map[string]PodProbeInfo {
+	if p == nil {
+		return nil
+	}
+	p.Cancel()
+	podsClient := p.clientSet.CoreV1().Pods(DefaultNamespace)
+	listOptions := metav1.ListOptions{LabelSelector: "app=" + probePodLabel}
+	var pods *corev1.PodList
+	err := wait.PollUntilContextTimeout(ctx, 5*time.Second, probeTimeout, true, func(ctx context.Context) (done bool, err error) {
+		pods, err = podsClient.List(ctx, listOptions)
+		if err != nil {
+			return false, err
+		}
+		for _, pod := range pods.Items {
+			if getContainerStartedTimestamp(&pod).IsZero() {
+				log.Debugf("Waiting for probe pod %s to run", pod.Name)
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		log.Errorf("Error waiting for probe pods to run: %v", err)
+	}
+	probeResults := make(map[string]PodProbeInfo)
+	if pods != nil {
+		for _, pod := range pods.Items {
+			info, probed := p.probes[pod.Name]
+			if !probed {
+				continue
+			}
+			info.creationTimestamp = pod.CreationTimestamp.Time.UTC()
+			info.scheduledTimestamp = getPodScheduledTimestamp(&pod)
+			info.pulledTimestamp = getImagePulledTimestamp(ctx, p.clientSet, pod.Name)
+			info.runningTimestamp = getContainerStartedTimestamp(&pod)
+			probeResults[pod.Name] = info
+		}
+	}
+	log.Debugf("Probe pods: %v", probeResults)
+	if err := DeleteProbePods(ctx, p.clientSet); err != nil {
+		log.Error(err)
+	} else {
+		UnregisterRollback(PodProbeRollback)
+	}
+	return probeResults
+}
+
+// DeleteProbePods deletes the probe pods
+func DeleteProbePods(ctx context.Context, clientSet kubernetes.Interface) error {
+	podsClient := clientSet.CoreV1().Pods(DefaultNamespace)
+	err := podsClient.DeleteCollection(ctx, metav1.DeleteOptions{GracePeriodSeconds: Int64Ptr(0)}, metav1.ListOptions{
+		LabelSelector: "app=" + probePodLabel,
+	})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("error deleting probe pods: %v", err)
+	}
+	ForgetCreatedResources(PodKind)
+	log.Infof("Probe pods deleted in namespace %s", DefaultNamespace)
+	return nil
+}
+
+// getPodScheduledTimestamp returns when a pod was scheduled
+func getPodScheduledTimestamp(pod *corev1.Pod) time.Time {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionTrue {
+			return condition.LastTransitionTime.Time.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+// getContainerStartedTimestamp returns when the container of a pod started, even if it already exited
+func getContainerStartedTimestamp(pod *corev1.Pod) time.Time {
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		if containerStatus.State.Running != nil {
+			return containerStatus.State.Running.StartedAt.Time.UTC()
+		}
+		if containerStatus.State.Terminated != nil {
+			return containerStatus.State.Terminated.StartedAt.Time.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+// getImagePulledTimestamp returns when the kubelet reported the image of a pod as pulled
+func getImagePulledTimestamp(ctx context.Context, clientSet kubernetes.Interface, podName string) time.Time {
+	events, err := clientSet.CoreV1().Events(DefaultNamespace).List(ctx, metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("involvedObject.kind=Pod,involvedObject.name=%s,reason=Pulled", podName),
+	})
+	if err != nil {
+		log.Debugf("error listing events of pod %s: %s", podName, err)
+		return time.Time{}
+	}
+	for _, event := range events.Items {
+		if !event.EventTime.IsZero() {
+			return event.EventTime.Time.UTC()
+		}
+		return event.FirstTimestamp.Time.UTC()
+	}
+	return time.Time{}
+}
+
+// FinalizePodProbeMetrics calculates and indexes the latencies of the probe pods relative to their node turning ready
+func FinalizePodProbeMetrics(uuid string, image string, probeResults map[string]PodProbeInfo, metadata map[string]interface{}, indexerValue indexers.Indexer, iteration int) {
+	if len(probeResults) == 0 {
+		return
+	}
+	var normLatencies, latencyQuantiles []interface{}
+	quantileMap := map[string][]float64{}
+	for pod, info := range probeResults {
+		podProbeMetric := PodProbeMetric{
+			Timestamp:               time.Now().UTC(),
+			NodeReadyTimestamp:      info.nodeReadyTimestamp,
+			PodCreationLatency:      phaseLatency(info.creationTimestamp, info.nodeReadyTimestamp),
+			PodScheduledLatency:     phaseLatency(info.scheduledTimestamp, info.nodeReadyTimestamp),
+			ImagePulledLatency:      phaseLatency(info.pulledTimestamp, info.nodeReadyTimestamp),
+			ContainerRunningLatency: phaseLatency(info.runningTimestamp, info.nodeReadyTimestamp),
+			MetricName:              podProbeLatencyMeasurement,
+			UUID:                    uuid,
+			JobName:                 JobName,
+			Name:                    pod,
+			NodeName:                info.nodeName,
+			Image:                   image,
+			Iteration:               iteration,
+			Metadata:                metadata,
+		}
+		quantileMap["PodCreation"] = append(quantileMap["PodCreation"], float64(podProbeMetric.PodCreationLatency))
+		quantileMap["PodScheduled"] = append(quantileMap["PodScheduled"], float64(podProbeMetric.PodScheduledLatency))
+		quantileMap["ImagePulled"] = append(quantileMap["ImagePulled"], float64(podProbeMetric.ImagePulledLatency))
+		quantileMap["ContainerRunning"] = append(quantileMap["ContainerRunning"], float64(podProbeMetric.ContainerRunningLatency))
+		normLatencies = append(normLatencies, podProbeMetric)
+	}
+	for condition, latencies := range quantileMap {
+		latencySummary := mmetrics.NewLatencySummary(latencies, condition)
+		latencySummary.UUID = uuid
+		latencySummary.MetricName = podProbeLatencyQuantilesMeasurement
+		latencySummary.JobName = JobName
+		latencySummary.Metadata = iterationMetadata(metadata, iteration)
+		log.Infof("%s: %s 50th: %v 99th: %v max: %v avg: %v", JobName, condition, latencySummary.P50, latencySummary.P99, latencySummary.Max, latencySummary.Avg)
+		latencyQuantiles = append(latencyQuantiles, latencySummary)
+	}
+	metricMap := map[string][]interface{}{
+		podProbeLatencyMeasurement:          normLatencies,
+		podProbeLatencyQuantilesMeasurement: latencyQuantiles,
+	}
+	measurements.IndexLatencyMeasurement(mtypes.Measurement{Name: measurementName}, JobName, metricMap, map[string]indexers.Indexer{
+		"": indexerValue,
+	})
+}
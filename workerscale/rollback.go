@@ -0,0 +1,97 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerscale
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rollbackStep reverts a change made to the cluster
+type rollbackStep struct {
+	name     string
+	rollback func() error
+}
+
+var rollbackSteps []rollbackStep
+var rollbackStepsLock sync.Mutex
+
+// rollbackLock serializes rollbacks triggered by a signal and a fatal error at the same time
+var rollbackLock sync.Mutex
+
+// SetupRollbackHandlers cancels the run on SIGINT or SIGTERM, and rolls back the registered changes on a second signal or a fatal error
+func SetupRollbackHandlers(cancel context.CancelFunc) {
+	log.RegisterExitHandler(Rollback)
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signalCh
+		// The failing scenario rolls back its changes and the run is still indexed
+		log.Warnf("Received %v, cancelling the run", sig)
+		cancel()
+		sig = <-signalCh
+		// A third signal terminates right away, skipping the rollback
+		signal.Stop(signalCh)
+		log.Warnf("Received %v again, rolling back the changes made to the cluster", sig)
+		Rollback()
+		os.Exit(1)
+	}()
+}
+
+// RegisterRollback registers a rollback step, replacing any previous step with the same name
+func RegisterRollback(name string, rollback func() error) {
+	rollbackStepsLock.Lock()
+	defer rollbackStepsLock.Unlock()
+	for i, step := range rollbackSteps {
+		if step.name == name {
+			rollbackSteps[i].rollback = rollback
+			return
+		}
+	}
+	rollbackSteps = append(rollbackSteps, rollbackStep{name: name, rollback: rollback})
+}
+
+// UnregisterRollback removes a rollback step once its change has been reverted by the scenario
+func UnregisterRollback(name string) {
+	rollbackStepsLock.Lock()
+	defer rollbackStepsLock.Unlock()
+	for i, step := range rollbackSteps {
+		if step.name == name {
+			rollbackSteps = append(rollbackSteps[:i], rollbackSteps[i+1:]...)
+			return
+		}
+	}
+}
+
+// Rollback runs the registered rollback steps in reverse order of registration
+func Rollback() {
+	rollbackLock.Lock()
+	defer rollbackLock.Unlock()
+	rollbackStepsLock.Lock()
+	steps := rollbackSteps
+	rollbackSteps = nil
+	rollbackStepsLock.Unlock()
+	for i := len(steps) - 1; i >= 0; i-- {
+		log.Infof("Rolling back %s", steps[i].name)
+		if err := steps[i].rollback(); err != nil {
+			log.Errorf("Error rolling back %s: %v", steps[i].name, err)
+		}
+	}
+}
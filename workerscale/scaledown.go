@@ -0,0 +1,162 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerscale
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/cloud-bulldozer/go-commons/indexers"
+	"github.com/kube-burner/kube-burner/pkg/measurements"
+	mmetrics "github.com/kube-burner/kube-burner/pkg/measurements/metrics"
+	mtypes "github.com/kube-burner/kube-burner/pkg/measurements/types"
+	machinev1beta1 "github.com/openshift/client-go/machine/clientset/versioned/typed/machine/v1beta1"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+)
+
+// ScaleDownTracker observes the deletion of worker machines and their nodes during a scale down
+type ScaleDownTracker struct {
+	watcher  *ClusterWatcher
+	release  func()
+	machines map[string]MachineDeletionInfo
+}
+
+// NewScaleDownTracker snapshots the worker machines that could be removed by the scale down
+func NewScaleDownTracker(ctx context.Context, machineClient *machinev1beta1.MachineV1beta1Client, clientSet kubernetes.Interface, filter *MachineSetFilter) (*ScaleDownTracker, error) {
+	selected, err := selectedMachineSets(ctx, machineClient, filter)
+	if err != nil {
+		return nil, err
+	}
+	watcher, release, err := acquireWatcher(ctx, machineClient, clientSet)
+	if err != nil {
+		return nil, err
+	}
+	tracker := &ScaleDownTracker{
+		watcher:  watcher,
+		release:  release,
+		machines: make(map[string]MachineDeletionInfo),
+	}
+	for _, machine := range watcher.Machines() {
+		role := machine.Labels["machine.openshift.io/cluster-api-machine-role"]
+		// Machines already being deleted before the scale down are not part of it
+		if role == "" || role == "master" || role == "infra" || role == "workload" || machine.Status.NodeRef == nil || machine.DeletionTimestamp != nil || !isMachineSelected(selected, machine.Labels) {
+			continue
+		}
+		tracker.machines[machine.Name] = MachineDeletionInfo{
+			machineSet: machine.Labels["machine.openshift.io/cluster-api-machineset"],
+			nodeName:   machine.Status.NodeRef.Name,
+		}
+	}
+	return tracker, nil
+}
+
+// Stop waits for the machines being deleted to be removed and returns their deletion details
+func (t *ScaleDownTracker) Stop(ctx context.Context) map[string]MachineDeletionInfo {
+	defer t.release()
+	err := t.watcher.WaitFor(ctx, func() bool {
+		for machine := range t.machines {
+			machineEvents := t.watcher.MachineEvents(machine)
+			_, deleting := machineEvents[machineDeletingEvent]
+			_, deleted := machineEvents[machineDeletedEvent]
+			if deleting && !deleted {
+				log.Debugf("Waiting for machine %s to be removed", machine)
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		log.Errorf("Error waiting for machines to be removed: %v", err)
+	}
+	deletedMachines := make(map[string]MachineDeletionInfo)
+	for machine, info := range t.machines {
+		machineEvents := t.watcher.MachineEvents(machine)
+		if _, deleting := machineEvents[machineDeletingEvent]; !deleting {
+			continue
+		}
+		nodeEvents := t.watcher.NodeEvents(info.nodeName)
+		info.deletionTimestamp = machineEvents[machineDeletingEvent]
+		info.drainTimestamp = machineEvents[machineDrainedEvent]
+		info.machineRemovalTimestamp = machineEvents[machineDeletedEvent]
+		info.cordonTimestamp = nodeEvents[nodeCordonedEvent]
+		info.nodeRemovalTimestamp = nodeEvents[nodeDeletedEvent]
+		deletedMachines[machine] = info
+	}
+	log.Debugf("Deleted machines: %v", deletedMachines)
+	return deletedMachines
+}
+
+// FinalizeScaleDownMetrics calculates and indexes the node deletion latencies
+func FinalizeScaleDownMetrics(uuid string, machineSetsToEdit *sync.Map, deletedMachines map[string]MachineDeletionInfo, metadata map[string]interface{}, indexerValue indexers.Indexer, iteration int) {
+	var normLatencies, latencyQuantiles []interface{}
+	quantileMap := map[string][]float64{}
+	for machine, info := range deletedMachines {
+		var scaleEventTimestamp time.Time
+		if msValue, exists := machineSetsToEdit.Load(info.machineSet); exists {
+			scaleEventTimestamp = msValue.(MachineSetInfo).LastUpdatedTime
+		} else {
+			scaleEventTimestamp = info.deletionTimestamp
+		}
+		nodeDeletionMetric := NodeDeletionMetric{
+			Timestamp:              time.Now().UTC(),
+			ScaleEventTimestamp:    scaleEventTimestamp,
+			MachineDeletionLatency: phaseLatency(info.deletionTimestamp, scaleEventTimestamp),
+			NodeCordonLatency:      phaseLatency(info.cordonTimestamp, scaleEventTimestamp),
+			NodeDrainLatency:       phaseLatency(info.drainTimestamp, scaleEventTimestamp),
+			NodeRemovalLatency:     phaseLatency(info.nodeRemovalTimestamp, scaleEventTimestamp),
+			MachineRemovalLatency:  phaseLatency(info.machineRemovalTimestamp, scaleEventTimestamp),
+			MetricName:             nodeDeletionLatencyMeasurement,
+			UUID:                   uuid,
+			JobName:                JobName,
+			Name:                   machine,
+			NodeName:               info.nodeName,
+			MachineSet:             info.machineSet,
+			Iteration:              iteration,
+			Metadata:               metadata,
+		}
+		quantileMap["MachineDeletion"] = append(quantileMap["MachineDeletion"], float64(nodeDeletionMetric.MachineDeletionLatency))
+		// Phases missed by the watcher, like a drain skipped on an unreachable node, are only reported when observed
+		phaseLatencies := map[string]int{
+			"NodeCordon":     nodeDeletionMetric.NodeCordonLatency,
+			"NodeDrain":      nodeDeletionMetric.NodeDrainLatency,
+			"NodeRemoval":    nodeDeletionMetric.NodeRemovalLatency,
+			"MachineRemoval": nodeDeletionMetric.MachineRemovalLatency,
+		}
+		for phase, latency := range phaseLatencies {
+			if latency != 0 {
+				quantileMap[phase] = append(quantileMap[phase], float64(latency))
+			}
+		}
+		normLatencies = append(normLatencies, nodeDeletionMetric)
+	}
+	for condition, latencies := range quantileMap {
+		latencySummary := mmetrics.NewLatencySummary(latencies, condition)
+		latencySummary.UUID = uuid
+		latencySummary.MetricName = nodeDeletionLatencyQuantilesMeasurement
+		latencySummary.JobName = JobName
+		latencySummary.Metadata = iterationMetadata(metadata, iteration)
+		log.Infof("%s: %s 50th: %v 99th: %v max: %v avg: %v", JobName, condition, latencySummary.P50, latencySummary.P99, latencySummary.Max, latencySummary.Avg)
+		latencyQuantiles = append(latencyQuantiles, latencySummary)
+	}
+	metricMap := map[string][]interface{}{
+		nodeDeletionLatencyMeasurement:          normLatencies,
+		nodeDeletionLatencyQuantilesMeasurement: latencyQuantiles,
+	}
+	measurements.IndexLatencyMeasurement(mtypes.Measurement{Name: measurementName}, JobName, metricMap, map[string]indexers.Indexer{
+		"": indexerValue,
+	})
+}
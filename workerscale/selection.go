@@ -0,0 +1,128 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerscale
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	machinev1beta1 "github.com/openshift/client-go/machine/clientset/versioned/typed/machine/v1beta1"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	capiv1beta1 "sigs.k8s.io/cluster-api/api/v1beta1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// MachineSetFilter selects the machinesets scaled, measured and waited on
+type MachineSetFilter struct {
+	selector labels.Selector
+	include  []string
+	exclude  []string
+}
+
+// NewMachineSetFilter restricts the run to the machinesets matching the label selector and name globs, nil when every machineset is selected
+func NewMachineSetFilter(selector string, include []string, exclude []string) (*MachineSetFilter, error) {
+	filter := &MachineSetFilter{include: include, exclude: exclude}
+	if selector != "" {
+		parsedSelector, err := labels.Parse(selector)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing machineset selector %s: %v", selector, err)
+		}
+		filter.selector = parsedSelector
+	}
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("error parsing machineset pattern %s: %v", pattern, err)
+		}
+	}
+	if filter.selector == nil && len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	log.Infof("Restricting the run to the machinesets matching selector %q, including %v and excluding %v", selector, include, exclude)
+	return filter, nil
+}
+
+// Matches tells whether a machineset is selected, a nil filter selects every machineset
+func (f *MachineSetFilter) Matches(name string, machineSetLabels map[string]string) bool {
+	if f == nil {
+		return true
+	}
+	if f.selector != nil && !f.selector.Matches(labels.Set(machineSetLabels)) {
+		return false
+	}
+	for _, pattern := range f.exclude {
+		if matched, _ := path.Match(pattern, name); matched {
+			return false
+		}
+	}
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, pattern := range f.include {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// selectedMachineSets returns the names of the selected machinesets, nil when every machineset is selected
+func selectedMachineSets(ctx context.Context, machineClient *machinev1beta1.MachineV1beta1Client, filter *MachineSetFilter) (map[string]bool, error) {
+	if filter == nil {
+		return nil, nil
+	}
+	machineSets, err := machineClient.MachineSets(MachineNamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error listing machinesets: %s", err)
+	}
+	selected := make(map[string]bool)
+	for _, ms := range machineSets.Items {
+		if filter.Matches(ms.Name, ms.Labels) {
+			selected[ms.Name] = true
+		}
+	}
+	return selected, nil
+}
+
+// isMachineSelected tells whether a machine belongs to one of the selected machinesets
+func isMachineSelected(selected map[string]bool, machineLabels map[string]string) bool {
+	return selected == nil || selected[machineLabels["machine.openshift.io/cluster-api-machineset"]]
+}
+
+// selectedMachineDeployments returns the names of the selected cluster api machinedeployments, nil when every machinedeployment is selected
+func selectedMachineDeployments(ctx context.Context, capiClient client.Client, clusterID string, namespace string, filter *MachineSetFilter) (map[string]bool, error) {
+	if filter == nil {
+		return nil, nil
+	}
+	machineDeploymentList := &capiv1beta1.MachineDeploymentList{}
+	labelSelector := client.MatchingLabels{capiv1beta1.ClusterNameLabel: clusterID}
+	if err := capiClient.List(ctx, machineDeploymentList, client.InNamespace(namespace), labelSelector); err != nil {
+		return nil, fmt.Errorf("error listing machinedeployments: %s", err)
+	}
+	selected := make(map[string]bool)
+	for _, md := range machineDeploymentList.Items {
+		if filter.Matches(md.Name, md.Labels) {
+			selected[md.Name] = true
+		}
+	}
+	return selected, nil
+}
+
+// isCAPIObjectSelected tells whether a cluster api machine or machineset belongs to one of the selected machinedeployments
+func isCAPIObjectSelected(selected map[string]bool, objectLabels map[string]string) bool {
+	return selected == nil || selected[objectLabels[capiv1beta1.MachineDeploymentNameLabel]]
+}
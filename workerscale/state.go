@@ -0,0 +1,272 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerscale
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// RunState records the changes made to the cluster by a run, to roll them back or resume the run after a crash
+type RunState struct {
+	UUID               string                    `json:"uuid"`
+	ScaleEventEpoch    int64                     `json:"scaleEventEpoch,omitempty"`
+	Completed          bool                      `json:"completed"`
+	MachineSets        map[string]MachineSetInfo `json:"machineSets,omitempty"`
+	ClusterID          string                    `json:"clusterID,omitempty"`
+	IsHCP              bool                      `json:"isHCP,omitempty"`
+	MachinePools       []MachinePool             `json:"machinePools,omitempty"`
+	MCKubeConfig       string                    `json:"mcKubeConfig,omitempty"`
+	NodePools          []NodePool                `json:"nodePools,omitempty"`
+	CAPINamespace      string                    `json:"capiNamespace,omitempty"`
+	MachineDeployments map[string]MachineSetInfo `json:"machineDeployments,omitempty"`
+	CreatedResources   map[string][]string       `json:"createdResources,omitempty"`
+}
+
+// MachineSetsToEdit returns the recorded machinesets in the format used to edit them
+func (runState RunState) MachineSetsToEdit() *sync.Map {
+	machineSetsToEdit := sync.Map{}
+	for machineSet, msInfo := range runState.MachineSets {
+		machineSetsToEdit.Store(machineSet, msInfo)
+	}
+	return &machineSetsToEdit
+}
+
+// MachineDeploymentsToEdit returns the recorded machinedeployments in the format used to edit them
+func (runState RunState) MachineDeploymentsToEdit() *sync.Map {
+	machineDeploymentsToEdit := sync.Map{}
+	for machineDeployment, mdInfo := range runState.MachineDeployments {
+		machineDeploymentsToEdit.Store(machineDeployment, mdInfo)
+	}
+	return &machineDeploymentsToEdit
+}
+
+var runState RunState
+var runStateLock sync.Mutex
+var runStateDir string
+var runStateClientSet kubernetes.Interface
+
+// InitRunState starts recording the state of a run, in a ConfigMap as well when a clientSet is given
+func InitRunState(uuid string, stateDir string, clientSet kubernetes.Interface) {
+	runStateLock.Lock()
+	defer runStateLock.Unlock()
+	runState = RunState{UUID: uuid}
+	runStateDir = stateDir
+	runStateClientSet = clientSet
+}
+
+// LoadRunState loads the state of a previous run from the state directory, falling back to its ConfigMap
+func LoadRunState(uuid string, stateDir string, clientSet kubernetes.Interface) (RunState, error) {
+	runStateLock.Lock()
+	defer runStateLock.Unlock()
+	runStateDir = stateDir
+	runStateClientSet = clientSet
+	stateData, err := os.ReadFile(runStateFile(uuid))
+	if err != nil {
+		if clientSet == nil {
+			return RunState{}, fmt.Errorf("error reading state file: %v", err)
+		}
+		configMap, cmErr := clientSet.CoreV1().ConfigMaps(DefaultNamespace).Get(context.TODO(), runStateName(uuid), metav1.GetOptions{})
+		if cmErr != nil {
+			return RunState{}, fmt.Errorf("error reading state file: %v, error getting state ConfigMap: %v", err, cmErr)
+		}
+		stateData = []byte(configMap.Data[runStateKey])
+	}
+	var loadedState RunState
+	if err := json.Unmarshal(stateData, &loadedState); err != nil {
+		return RunState{}, fmt.Errorf("error parsing state of run %s: %v", uuid, err)
+	}
+	runState = loadedState
+	return runState, nil
+}
+
+// RecordMachineSets records the original replicas of the machinesets about to be scaled
+func RecordMachineSets(machineSetsToEdit *sync.Map) {
+	runStateLock.Lock()
+	defer runStateLock.Unlock()
+	runState.MachineSets = make(map[string]MachineSetInfo)
+	machineSetsToEdit.Range(func(key, value interface{}) bool {
+		runState.MachineSets[key.(string)] = value.(MachineSetInfo)
+		return true
+	})
+	runState.ScaleEventEpoch = time.Now().Unix()
+	runState.Completed = false
+	saveRunState()
+}
+
+// RecordMachinePools records the original ROSA machinepools about to be scaled
+func RecordMachinePools(clusterID string, isHCP bool, machinePools []MachinePool) {
+	runStateLock.Lock()
+	defer runStateLock.Unlock()
+	runState.ClusterID = clusterID
+	runState.IsHCP = isHCP
+	runState.MachinePools = machinePools
+	runState.ScaleEventEpoch = time.Now().Unix()
+	runState.Completed = false
+	saveRunState()
+}
+
+// RecordNodePools records the original HyperShift nodepools about to be scaled, along with the management cluster kubeconfig
+func RecordNodePools(mcKubeConfig string, nodePools []NodePool) {
+	runStateLock.Lock()
+	defer runStateLock.Unlock()
+	runState.MCKubeConfig = mcKubeConfig
+	runState.NodePools = nodePools
+	runState.ScaleEventEpoch = time.Now().Unix()
+	runState.Completed = false
+	saveRunState()
+}
+
+// RecordMachineDeployments records the original replicas of the cluster api machinedeployments about to be scaled
+func RecordMachineDeployments(mcKubeConfig string, namespace string, machineDeploymentsToEdit *sync.Map) {
+	runStateLock.Lock()
+	defer runStateLock.Unlock()
+	runState.MCKubeConfig = mcKubeConfig
+	runState.CAPINamespace = namespace
+	runState.MachineDeployments = make(map[string]MachineSetInfo)
+	machineDeploymentsToEdit.Range(func(key, value interface{}) bool {
+		runState.MachineDeployments[key.(string)] = value.(MachineSetInfo)
+		return true
+	})
+	runState.ScaleEventEpoch = time.Now().Unix()
+	runState.Completed = false
+	saveRunState()
+}
+
+// RecordCreatedResource records a resource created by the run
+func RecordCreatedResource(kind string, name string) {
+	runStateLock.Lock()
+	defer runStateLock.Unlock()
+	if runState.CreatedResources == nil {
+		runState.CreatedResources = make(map[string][]string)
+	}
+	if !slices.Contains(runState.CreatedResources[kind], name) {
+		runState.CreatedResources[kind] = append(runState.CreatedResources[kind], name)
+	}
+	saveRunState()
+}
+
+// ForgetCreatedResource forgets a resource once it has been deleted
+func ForgetCreatedResource(kind string, name string) {
+	runStateLock.Lock()
+	defer runStateLock.Unlock()
+	index := slices.Index(runState.CreatedResources[kind], name)
+	if index < 0 {
+		return
+	}
+	runState.CreatedResources[kind] = slices.Delete(runState.CreatedResources[kind], index, index+1)
+	if len(runState.CreatedResources[kind]) == 0 {
+		delete(runState.CreatedResources, kind)
+	}
+	saveRunState()
+}
+
+// ForgetCreatedResources forgets all the resources of a kind once they have been deleted
+func ForgetCreatedResources(kind string) {
+	runStateLock.Lock()
+	defer runStateLock.Unlock()
+	if _, exists := runState.CreatedResources[kind]; !exists {
+		return
+	}
+	delete(runState.CreatedResources, kind)
+	saveRunState()
+}
+
+// CompleteRunState removes the state once the cluster is restored, otherwise keeps it around for a later cleanup
+func CompleteRunState(restored bool) {
+	if restored {
+		RemoveRunState()
+		return
+	}
+	runStateLock.Lock()
+	defer runStateLock.Unlock()
+	runState.Completed = true
+	saveRunState()
+}
+
+// RemoveRunState removes the state file and ConfigMap of the run
+func RemoveRunState() {
+	runStateLock.Lock()
+	defer runStateLock.Unlock()
+	if runState.UUID == "" {
+		return
+	}
+	if err := os.Remove(runStateFile(runState.UUID)); err != nil && !os.IsNotExist(err) {
+		log.Errorf("Error removing state file: %v", err)
+	}
+	if runStateClientSet != nil {
+		err := runStateClientSet.CoreV1().ConfigMaps(DefaultNamespace).Delete(context.TODO(), runStateName(runState.UUID), metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			log.Errorf("Error deleting state ConfigMap: %v", err)
+		}
+	}
+	log.Debugf("State of run %s removed", runState.UUID)
+}
+
+// saveRunState persists the state of the run, must be called with the state lock held
+func saveRunState() {
+	if runState.UUID == "" {
+		return
+	}
+	stateData, err := json.MarshalIndent(runState, "", "  ")
+	if err != nil {
+		log.Errorf("Error marshaling run state: %v", err)
+		return
+	}
+	if err := os.WriteFile(runStateFile(runState.UUID), stateData, 0644); err != nil {
+		log.Errorf("Error writing state file: %v", err)
+	}
+	if runStateClientSet != nil {
+		configMap := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      runStateName(runState.UUID),
+				Namespace: DefaultNamespace,
+			},
+			Data: map[string]string{
+				runStateKey: string(stateData),
+			},
+		}
+		configMaps := runStateClientSet.CoreV1().ConfigMaps(DefaultNamespace)
+		_, err := configMaps.Update(context.TODO(), configMap, metav1.UpdateOptions{})
+		if errors.IsNotFound(err) {
+			_, err = configMaps.Create(context.TODO(), configMap, metav1.CreateOptions{})
+		}
+		if err != nil {
+			log.Errorf("Error saving state ConfigMap: %v", err)
+		}
+	}
+}
+
+// runStateName returns the name of the state file and ConfigMap of a run
+func runStateName(uuid string) string {
+	return fmt.Sprintf("%s-%s", JobName, uuid)
+}
+
+// runStateFile returns the path of the state file of a run
+func runStateFile(uuid string) string {
+	return filepath.Join(runStateDir, runStateName(uuid)+".json")
+}
@@ -0,0 +1,90 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerscale
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// GetLifecycleTimelines fills in the lifecycle steps observed by the cluster watcher and the first workload pod scheduled on every node
+func GetLifecycleTimelines(ctx context.Context, clientSet kubernetes.Interface, machineDetails map[string]MachineInfo) error {
+	clusterWatcherLock.Lock()
+	watcher := clusterWatcher
+	clusterWatcherLock.Unlock()
+	for machine, info := range machineDetails {
+		info.timeline = make(map[string]time.Time)
+		if watcher != nil {
+			machineEvents := watcher.MachineEvents(machine)
+			nodeEvents := watcher.NodeEvents(info.nodeName)
+			info.timeline["MachineProvisioning"] = machineEvents[machineProvisioningPhase]
+			info.timeline["MachineProvisioned"] = machineEvents[machineProvisionedPhase]
+			info.timeline["MachineRunning"] = machineEvents[machineRunningPhase]
+			info.timeline["ProviderID"] = machineEvents[machineProviderIDEvent]
+			info.timeline["ClientCSRCreation"] = nodeEvents[nodeClientCSRCreatedEvent]
+			info.timeline["ClientCSRApproval"] = nodeEvents[nodeClientCSRApprovedEvent]
+			info.timeline["ServingCSRCreation"] = nodeEvents[nodeServingCSRCreatedEvent]
+			info.timeline["ServingCSRApproval"] = nodeEvents[nodeServingCSRApprovedEvent]
+			info.timeline["NetworkReady"] = nodeEvents[nodeNetworkReadyEvent]
+			info.timeline["MachineConfigDone"] = nodeEvents[nodeMachineConfigDoneEvent]
+		}
+		firstPodScheduled, err := getFirstPodScheduled(ctx, clientSet, info.nodeName)
+		if err != nil {
+			return err
+		}
+		info.timeline["FirstPodScheduled"] = firstPodScheduled
+		machineDetails[machine] = info
+	}
+	log.Debugf("Machines with lifecycle timelines: %v", machineDetails)
+	return nil
+}
+
+// getFirstPodScheduled returns when the first pod not managed by a daemonset was scheduled on a node
+func getFirstPodScheduled(ctx context.Context, clientSet kubernetes.Interface, nodeName string) (time.Time, error) {
+	var firstPodScheduled time.Time
+	if nodeName == "" {
+		return firstPodScheduled, nil
+	}
+	pods, err := clientSet.CoreV1().Pods(metav1.NamespaceAll).List(ctx, metav1.ListOptions{
+		FieldSelector: "spec.nodeName=" + nodeName,
+	})
+	if err != nil {
+		return firstPodScheduled, fmt.Errorf("error listing pods on node %s: %v", nodeName, err)
+	}
+	for _, pod := range pods.Items {
+		// Static pods are not scheduled and daemonset pods land on every node regardless of its capacity
+		if _, isMirror := pod.Annotations[corev1.MirrorPodAnnotationKey]; isMirror {
+			continue
+		}
+		if ownerRef := metav1.GetControllerOf(&pod); ownerRef != nil && ownerRef.Kind == "DaemonSet" {
+			continue
+		}
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodScheduled && condition.Status == corev1.ConditionTrue {
+				scheduledTimestamp := condition.LastTransitionTime.Time.UTC()
+				if firstPodScheduled.IsZero() || scheduledTimestamp.Before(firstPodScheduled) {
+					firstPodScheduled = scheduledTimestamp
+				}
+			}
+		}
+	}
+	return firstPodScheduled, nil
+}
@@ -15,15 +15,17 @@
 package workerscale
 
 import (
+	"context"
 	"time"
 
 	"github.com/cloud-bulldozer/go-commons/indexers"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // Interface for our scenarios
 type Scenario interface {
-	OrchestrateWorkload(ScaleConfig) string
+	OrchestrateWorkload(context.Context, ScaleConfig) (string, error)
 }
 
 // ScaleConfig contains configuration for scaling
@@ -37,6 +39,31 @@ type ScaleConfig struct {
 	AutoScalerEnabled     bool
 	MCKubeConfig          string
 	IsHCP                 bool
+	Platform              string
+	CAPIClusterName       string
+	CAPINamespace         string
+	Iteration             int
+	ScaleUpTimeout        time.Duration
+	ScaleDownTimeout      time.Duration
+	ProbeImage            string
+	PlacementStrategy     string
+	PlacementWeights      map[string]int
+	PlacementTarget       string
+	PlacementCounts       map[string]int
+	MachineSetFilter      *MachineSetFilter
+	EphemeralMachineSet   EphemeralMachineSetConfig
+}
+
+// EphemeralMachineSetConfig describes the machineset cloned for the run, disabled without a template
+type EphemeralMachineSetConfig struct {
+	Template            string
+	InstanceType        string
+	BootImage           string
+	Zone                string
+	Labels              map[string]string
+	Taints              []corev1.Taint
+	CompareBootImage    string
+	CompareInstanceType string
 }
 
 // Struct to extract AMIID from aws provider spec
@@ -46,11 +73,153 @@ type AWSProviderSpec struct {
 	} `json:"ami"`
 }
 
+// Struct to extract image reference from azure provider spec
+type AzureProviderSpec struct {
+	Image struct {
+		Publisher  string `json:"publisher"`
+		Offer      string `json:"offer"`
+		SKU        string `json:"sku"`
+		Version    string `json:"version"`
+		ResourceID string `json:"resourceID"`
+	} `json:"image"`
+}
+
+// Struct to extract disk images from gcp provider spec
+type GCPProviderSpec struct {
+	Zone  string `json:"zone"`
+	Disks []struct {
+		Boot  bool   `json:"boot"`
+		Image string `json:"image"`
+	} `json:"disks"`
+}
+
+// Struct to extract the zone from aws, azure and gcp provider specs
+type ProviderSpecZone struct {
+	Zone      string `json:"zone"`
+	Placement struct {
+		AvailabilityZone string `json:"availabilityZone"`
+	} `json:"placement"`
+}
+
+// Struct to extract the template from vsphere provider spec
+type VSphereProviderSpec struct {
+	Template string `json:"template"`
+}
+
+// Struct to extract the image from baremetal provider spec
+type BareMetalProviderSpec struct {
+	Image struct {
+		URL string `json:"url"`
+	} `json:"image"`
+	CustomDeploy struct {
+		Method string `json:"method"`
+	} `json:"customDeploy"`
+}
+
+// Struct to extract AMIID from AWSMachineTemplate
+type AWSMachineTemplate struct {
+	Spec struct {
+		Template struct {
+			Spec struct {
+				AMI struct {
+					ID string `json:"id"`
+				} `json:"ami"`
+			} `json:"spec"`
+		} `json:"template"`
+	} `json:"spec"`
+}
+
+// Struct to extract image from AzureMachineTemplate
+type AzureMachineTemplate struct {
+	Spec struct {
+		Template struct {
+			Spec struct {
+				Image struct {
+					ID          string `json:"id"`
+					Marketplace struct {
+						Publisher string `json:"publisher"`
+						Offer     string `json:"offer"`
+						SKU       string `json:"sku"`
+						Version   string `json:"version"`
+					} `json:"marketplace"`
+					ComputeGallery struct {
+						Gallery string `json:"gallery"`
+						Name    string `json:"name"`
+						Version string `json:"version"`
+					} `json:"computeGallery"`
+				} `json:"image"`
+			} `json:"spec"`
+		} `json:"template"`
+	} `json:"spec"`
+}
+
+// Struct to extract image from GCPMachineTemplate
+type GCPMachineTemplate struct {
+	Spec struct {
+		Template struct {
+			Spec struct {
+				Image       string `json:"image"`
+				ImageFamily string `json:"imageFamily"`
+			} `json:"spec"`
+		} `json:"template"`
+	} `json:"spec"`
+}
+
+// Struct to extract custom image from DockerMachineTemplate
+type DockerMachineTemplate struct {
+	Spec struct {
+		Template struct {
+			Spec struct {
+				CustomImage string `json:"customImage"`
+			} `json:"spec"`
+		} `json:"template"`
+	} `json:"spec"`
+}
+
+// Struct to extract VM template from VSphereMachineTemplate
+type VSphereMachineTemplate struct {
+	Spec struct {
+		Template struct {
+			Spec struct {
+				Template string `json:"template"`
+			} `json:"spec"`
+		} `json:"template"`
+	} `json:"spec"`
+}
+
 // MachineInfo provides information about a machine resource
 type MachineInfo struct {
-	nodeUID           string
-	creationTimestamp time.Time
-	readyTimestamp    time.Time
+	nodeUID               string
+	nodeName              string
+	machineSet            string
+	bootImageID           string
+	hostRef               string
+	creationTimestamp     time.Time
+	readyTimestamp        time.Time
+	inspectionTimestamp   time.Time
+	provisioningTimestamp time.Time
+	timeline              map[string]time.Time
+}
+
+// MachineDeletionInfo provides information about a machine removed during a scale down
+type MachineDeletionInfo struct {
+	machineSet              string
+	nodeName                string
+	deletionTimestamp       time.Time
+	cordonTimestamp         time.Time
+	drainTimestamp          time.Time
+	nodeRemovalTimestamp    time.Time
+	machineRemovalTimestamp time.Time
+}
+
+// PodProbeInfo provides information about a pod probing a scaled node
+type PodProbeInfo struct {
+	nodeName           string
+	nodeReadyTimestamp time.Time
+	creationTimestamp  time.Time
+	scheduledTimestamp time.Time
+	pulledTimestamp    time.Time
+	runningTimestamp   time.Time
 }
 
 // MachineSetInfo provides information about a machineset resource
@@ -67,6 +236,18 @@ type MachinePool struct {
 	Autoscaling Autoscaling `json:"autoscaling"`
 }
 
+// NodePool of a HyperShift hosted cluster
+type NodePool struct {
+	Name          string
+	Namespace     string
+	Replicas      int
+	AutoScaling   bool
+	MinReplicas   int
+	MaxReplicas   int
+	ReleaseImage  string
+	PlatformImage string
+}
+
 // AutoScaling configuration for ROSA
 type Autoscaling struct {
 	// ROSA Classic fields
@@ -93,29 +274,72 @@ type ProviderStatus struct {
 
 // NodeReadyMetric to capture details on node bootup
 type NodeReadyMetric struct {
-	Timestamp                time.Time         `json:"timestamp"`
-	ScaleEventTimestamp      time.Time         `json:"scaleEventTimestamp"`
-	MachineCreationTimestamp time.Time         `json:"-"`
-	MachineCreationLatency   int               `json:"machineCreationLatency"`
-	MachineReadyTimestamp    time.Time         `json:"-"`
-	MachineReadyLatency      int               `json:"machineReadyLatency"`
-	NodeCreationTimestamp    time.Time         `json:"-"`
-	NodeCreationLatency      int               `json:"nodeCreationLatency"`
-	NodeReadyTimestamp       time.Time         `json:"-"`
-	NodeReadyLatency         int               `json:"nodeReadyLatency"`
-	MetricName               string            `json:"metricName"`
-	AMIID                    string            `json:"amiID"`
-	UUID                     string            `json:"uuid"`
-	JobName                  string            `json:"jobName,omitempty"`
-	Name                     string            `json:"nodeName"`
-	Labels                   map[string]string `json:"labels"`
-	Metadata                 interface{}       `json:"metadata,omitempty"`
+	Timestamp                    time.Time         `json:"timestamp"`
+	ScaleEventTimestamp          time.Time         `json:"scaleEventTimestamp"`
+	MachineCreationTimestamp     time.Time         `json:"-"`
+	MachineCreationLatency       int               `json:"machineCreationLatency"`
+	MachineReadyTimestamp        time.Time         `json:"-"`
+	MachineReadyLatency          int               `json:"machineReadyLatency"`
+	NodeCreationTimestamp        time.Time         `json:"-"`
+	NodeCreationLatency          int               `json:"nodeCreationLatency"`
+	NodeReadyTimestamp           time.Time         `json:"-"`
+	NodeReadyLatency             int               `json:"nodeReadyLatency"`
+	HostInspectionTimestamp      time.Time         `json:"-"`
+	HostInspectionLatency        int               `json:"hostInspectionLatency,omitempty"`
+	HostProvisionTimestamp       time.Time         `json:"-"`
+	HostProvisionLatency         int               `json:"hostProvisionLatency,omitempty"`
+	MachineProvisioningTimestamp time.Time         `json:"-"`
+	MachineProvisioningLatency   int               `json:"machineProvisioningLatency,omitempty"`
+	MachineProvisionedTimestamp  time.Time         `json:"-"`
+	MachineProvisionedLatency    int               `json:"machineProvisionedLatency,omitempty"`
+	MachineRunningTimestamp      time.Time         `json:"-"`
+	MachineRunningLatency        int               `json:"machineRunningLatency,omitempty"`
+	ProviderIDTimestamp          time.Time         `json:"-"`
+	ProviderIDLatency            int               `json:"providerIDLatency,omitempty"`
+	ClientCSRCreationTimestamp   time.Time         `json:"-"`
+	ClientCSRCreationLatency     int               `json:"clientCSRCreationLatency,omitempty"`
+	ClientCSRApprovalTimestamp   time.Time         `json:"-"`
+	ClientCSRApprovalLatency     int               `json:"clientCSRApprovalLatency,omitempty"`
+	ServingCSRCreationTimestamp  time.Time         `json:"-"`
+	ServingCSRCreationLatency    int               `json:"servingCSRCreationLatency,omitempty"`
+	ServingCSRApprovalTimestamp  time.Time         `json:"-"`
+	ServingCSRApprovalLatency    int               `json:"servingCSRApprovalLatency,omitempty"`
+	ClientCSRPendingLatency      int               `json:"clientCSRPendingLatency,omitempty"`
+	ServingCSRPendingLatency     int               `json:"servingCSRPendingLatency,omitempty"`
+	NetworkReadyTimestamp        time.Time         `json:"-"`
+	NetworkReadyLatency          int               `json:"networkReadyLatency,omitempty"`
+	MachineConfigDoneTimestamp   time.Time         `json:"-"`
+	MachineConfigDoneLatency     int               `json:"machineConfigDoneLatency,omitempty"`
+	FirstPodScheduledTimestamp   time.Time         `json:"-"`
+	FirstPodScheduledLatency     int               `json:"firstPodScheduledLatency,omitempty"`
+	MetricName                   string            `json:"metricName"`
+	AMIID                        string            `json:"amiID"`
+	BootImageID                  string            `json:"bootImageID"`
+	Iteration                    int               `json:"iteration"`
+	UUID                         string            `json:"uuid"`
+	JobName                      string            `json:"jobName,omitempty"`
+	Name                         string            `json:"nodeName"`
+	Labels                       map[string]string `json:"labels"`
+	Metadata                     interface{}       `json:"metadata,omitempty"`
+	MachineSet                   string            `json:"machineSet,omitempty"`
+	InstanceType                 string            `json:"instanceType,omitempty"`
+	Architecture                 string            `json:"architecture,omitempty"`
+	Zone                         string            `json:"zone,omitempty"`
+	Region                       string            `json:"region,omitempty"`
 }
 
 // NodeReadyStacked to capture details on node bootup
 type NodeReadyLatencyStackedMeasurement struct {
 	UUID                string      `json:"uuid"`
 	BootImageID         string      `json:"bootImageID"`
+	GroupBy             string      `json:"groupBy,omitempty"`
+	Group               string      `json:"group,omitempty"`
+	MachineSet          string      `json:"machineSet,omitempty"`
+	InstanceType        string      `json:"instanceType,omitempty"`
+	Architecture        string      `json:"architecture,omitempty"`
+	Zone                string      `json:"zone,omitempty"`
+	Region              string      `json:"region,omitempty"`
+	Iteration           int         `json:"iteration"`
 	MachineCreation_P99 int         `json:"machineCreation_P99"`
 	MachineCreation_P95 int         `json:"machineCreation_P95"`
 	MachineCreation_P50 int         `json:"machineCreation_P50"`
@@ -145,3 +369,89 @@ type NodeReadyLatencyStackedMeasurement struct {
 	JobName             string      `json:"jobName,omitempty"`
 	Metadata            interface{} `json:"metadata,omitempty"`
 }
+
+// NodeDeletionMetric to capture details on node removal
+type NodeDeletionMetric struct {
+	Timestamp              time.Time   `json:"timestamp"`
+	ScaleEventTimestamp    time.Time   `json:"scaleEventTimestamp"`
+	MachineDeletionLatency int         `json:"machineDeletionLatency"`
+	NodeCordonLatency      int         `json:"nodeCordonLatency"`
+	NodeDrainLatency       int         `json:"nodeDrainLatency"`
+	NodeRemovalLatency     int         `json:"nodeRemovalLatency"`
+	MachineRemovalLatency  int         `json:"machineRemovalLatency"`
+	MetricName             string      `json:"metricName"`
+	UUID                   string      `json:"uuid"`
+	JobName                string      `json:"jobName,omitempty"`
+	Name                   string      `json:"machineName"`
+	NodeName               string      `json:"nodeName"`
+	MachineSet             string      `json:"machineSet"`
+	Iteration              int         `json:"iteration"`
+	Metadata               interface{} `json:"metadata,omitempty"`
+}
+
+// PodProbeMetric to capture details on the first pod run on a scaled node
+type PodProbeMetric struct {
+	Timestamp               time.Time   `json:"timestamp"`
+	NodeReadyTimestamp      time.Time   `json:"nodeReadyTimestamp"`
+	PodCreationLatency      int         `json:"podCreationLatency"`
+	PodScheduledLatency     int         `json:"podScheduledLatency"`
+	ImagePulledLatency      int         `json:"imagePulledLatency"`
+	ContainerRunningLatency int         `json:"containerRunningLatency"`
+	MetricName              string      `json:"metricName"`
+	UUID                    string      `json:"uuid"`
+	JobName                 string      `json:"jobName,omitempty"`
+	Name                    string      `json:"podName"`
+	NodeName                string      `json:"nodeName"`
+	Image                   string      `json:"image"`
+	Iteration               int         `json:"iteration"`
+	Metadata                interface{} `json:"metadata,omitempty"`
+}
+
+// FailedMachineMetric to capture details on a machine which did not reach the Running phase
+type FailedMachineMetric struct {
+	Timestamp         time.Time                 `json:"timestamp"`
+	CreationTimestamp time.Time                 `json:"creationTimestamp"`
+	Phase             string                    `json:"phase"`
+	ErrorReason       string                    `json:"errorReason,omitempty"`
+	ErrorMessage      string                    `json:"errorMessage,omitempty"`
+	Conditions        []ProviderStatusCondition `json:"conditions,omitempty"`
+	Events            []string                  `json:"events,omitempty"`
+	MetricName        string                    `json:"metricName"`
+	UUID              string                    `json:"uuid"`
+	JobName           string                    `json:"jobName,omitempty"`
+	Name              string                    `json:"machineName"`
+	MachineSet        string                    `json:"machineSet"`
+	Iteration         int                       `json:"iteration"`
+	Metadata          interface{}               `json:"metadata,omitempty"`
+}
+
+// NodeReadyLatencyComparisonMetric to capture the latencies of a phase on the baseline and candidate machinesets side by side
+type NodeReadyLatencyComparisonMetric struct {
+	Timestamp             time.Time   `json:"timestamp"`
+	MetricName            string      `json:"metricName"`
+	UUID                  string      `json:"uuid"`
+	JobName               string      `json:"jobName,omitempty"`
+	Phase                 string      `json:"phase"`
+	BaselineMachineSet    string      `json:"baselineMachineSet"`
+	BaselineBootImageID   string      `json:"baselineBootImageID"`
+	BaselineInstanceType  string      `json:"baselineInstanceType,omitempty"`
+	BaselineCount         int         `json:"baselineCount"`
+	BaselineP99           int         `json:"baselineP99"`
+	BaselineP50           int         `json:"baselineP50"`
+	BaselineAvg           int         `json:"baselineAvg"`
+	CandidateMachineSet   string      `json:"candidateMachineSet"`
+	CandidateBootImageID  string      `json:"candidateBootImageID"`
+	CandidateInstanceType string      `json:"candidateInstanceType,omitempty"`
+	CandidateCount        int         `json:"candidateCount"`
+	CandidateP99          int         `json:"candidateP99"`
+	CandidateP50          int         `json:"candidateP50"`
+	CandidateAvg          int         `json:"candidateAvg"`
+	DeltaP99              int         `json:"deltaP99"`
+	DeltaP50              int         `json:"deltaP50"`
+	DeltaAvg              int         `json:"deltaAvg"`
+	DeltaAvgPercent       float64     `json:"deltaAvgPercent"`
+	PValue                float64     `json:"pValue"`
+	Significant           bool        `json:"significant"`
+	Iteration             int         `json:"iteration"`
+	Metadata              interface{} `json:"metadata,omitempty"`
+}
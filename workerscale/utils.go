@@ -16,6 +16,7 @@ package workerscale
 
 import (
 	"context"
+	"fmt"
 	"strings"
 	"time"
 
@@ -24,7 +25,6 @@ import (
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	infrav1 "sigs.k8s.io/cluster-api-provider-aws/v2/api/v1beta2"
@@ -37,6 +37,11 @@ func Int32Ptr(i int32) *int32 {
 	return &i
 }
 
+// helper function to create a pointer to an int64
+func Int64Ptr(i int64) *int64 {
+	return &i
+}
+
 // DiscardPreviousMachines updates the current machines details discarding the previous ones
 func DiscardPreviousMachines(prevMachineDetails map[string]MachineInfo, currentMachineDetails map[string]MachineInfo) {
 	for key := range currentMachineDetails {
@@ -47,30 +52,30 @@ func DiscardPreviousMachines(prevMachineDetails map[string]MachineInfo, currentM
 }
 
 // GetMachineClient creates a reusable machine client
-func GetMachineClient(restConfig *rest.Config) *machinev1beta1.MachineV1beta1Client {
+func GetMachineClient(restConfig *rest.Config) (*machinev1beta1.MachineV1beta1Client, error) {
 	machineClient, err := machinev1beta1.NewForConfig(restConfig)
 	if err != nil {
-		log.Fatalf("error creating machine API client: %s", err)
+		return nil, fmt.Errorf("error creating machine API client: %s", err)
 	}
 
-	return machineClient
+	return machineClient, nil
 }
 
-// GetCAPIClient create a cluster api client
-func GetCAPIClient(restConfig *rest.Config) client.Client {
+// GetCAPIClient create a cluster api client, able to watch the objects it waits on
+func GetCAPIClient(restConfig *rest.Config) (client.WithWatch, error) {
 	capiScheme := runtime.NewScheme()
 	if err := v1beta1.AddToScheme(capiScheme); err != nil {
-		log.Fatalf("error adding CAPI types to scheme: %s", err)
+		return nil, fmt.Errorf("error adding CAPI types to scheme: %s", err)
 	}
 	if err := infrav1.AddToScheme(capiScheme); err != nil {
-		log.Fatalf("error adding AWS CAPI types to scheme: %s", err)
+		return nil, fmt.Errorf("error adding AWS CAPI types to scheme: %s", err)
 	}
-	capiClient, err := client.New(restConfig, client.Options{Scheme: capiScheme})
+	capiClient, err := client.NewWithWatch(restConfig, client.Options{Scheme: capiScheme})
 	if err != nil {
-		log.Fatalf("error creating CAPI client: %s", err)
+		return nil, fmt.Errorf("error creating CAPI client: %s", err)
 	}
 
-	return capiClient
+	return capiClient, nil
 }
 
 // isNodeReady checks if a node is ready
@@ -84,29 +89,49 @@ func isNodeReady(node *v1.Node) bool {
 }
 
 // WaitForNodes waits for all the nodes to be ready
-func WaitForNodes(clientset kubernetes.Interface) error {
-	return wait.PollUntilContextTimeout(context.TODO(), time.Second, maxWaitTimeout, true, func(ctx context.Context) (done bool, err error) {
-		nodes, err := clientset.CoreV1().Nodes().List(context.TODO(), metav1.ListOptions{})
-		if err != nil {
-			return false, err
-		}
-
-		for _, node := range nodes.Items {
-			if !isNodeReady(&node) {
+func WaitForNodes(ctx context.Context, clientset kubernetes.Interface) error {
+	watcher, release, err := acquireWatcher(ctx, nil, clientset)
+	if err != nil {
+		return err
+	}
+	defer release()
+	err = watcher.WaitFor(ctx, func() bool {
+		for _, node := range watcher.Nodes() {
+			if !isNodeReady(node) {
 				log.Debugf("Node %s is not ready", node.Name)
-				return false, nil
+				return false
 			}
 		}
-		log.Infof("All nodes are ready")
-		return true, nil
+		return true
 	})
+	if err != nil {
+		// Nodes never turn ready when their CSRs are not approved
+		if csrIssues := watcher.CSRIssues(); len(csrIssues) > 0 {
+			return fmt.Errorf("%v, unapproved CSRs: %s", err, joinCSRIssues(csrIssues))
+		}
+		return err
+	}
+	log.Infof("All nodes are ready")
+	return nil
+}
+
+// Sleep pauses for the given duration, returning early when the context is done
+func Sleep(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
 }
 
 // GetHCNamespace gets the longest hosted cluster namespace from management cluster
-func GetHCNamespace(clientset kubernetes.Interface, clusterID string) string {
-	namespaces, err := clientset.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{})
+func GetHCNamespace(ctx context.Context, clientset kubernetes.Interface, clusterID string) (string, error) {
+	namespaces, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
 	if err != nil {
-		log.Fatalf("Error listing the namespaces: %s", err)
+		return "", fmt.Errorf("error listing the namespaces: %s", err)
 	}
 
 	longestNamespace := ""
@@ -119,5 +144,5 @@ func GetHCNamespace(clientset kubernetes.Interface, clusterID string) string {
 			}
 		}
 	}
-	return longestNamespace
+	return longestNamespace, nil
 }
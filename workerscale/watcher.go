@@ -0,0 +1,459 @@
+// Copyright 2024 workers-scale Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package workerscale
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	machinev1 "github.com/openshift/api/machine/v1beta1"
+	machinev1beta1 "github.com/openshift/client-go/machine/clientset/versioned/typed/machine/v1beta1"
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ClusterWatcher keeps a view of the machines, machinesets, nodes and their CSRs through shared informers,
+// recording when every machine and node reaches each step of its lifecycle
+type ClusterWatcher struct {
+	machineClient      *machinev1beta1.MachineV1beta1Client
+	clientSet          kubernetes.Interface
+	machineInformer    cache.SharedIndexInformer
+	machineSetInformer cache.SharedIndexInformer
+	nodeInformer       cache.SharedIndexInformer
+	csrInformer        cache.SharedIndexInformer
+	mu                 sync.Mutex
+	changed            chan struct{}
+	machineEvents      map[string]map[string]time.Time
+	nodeEvents         map[string]map[string]time.Time
+	csrs               map[string]csrStatus
+	initialNodes       map[string]bool
+	cancel             context.CancelFunc
+}
+
+var clusterWatcher *ClusterWatcher
+var clusterWatcherLock sync.Mutex
+
+// StartClusterWatcher starts the watcher shared by the waits on the given clients until it is stopped
+func StartClusterWatcher(ctx context.Context, machineClient *machinev1beta1.MachineV1beta1Client, clientSet kubernetes.Interface) error {
+	watcher, err := newClusterWatcher(ctx, machineClient, clientSet)
+	if err != nil {
+		return err
+	}
+	clusterWatcherLock.Lock()
+	defer clusterWatcherLock.Unlock()
+	if clusterWatcher != nil {
+		clusterWatcher.stop()
+	}
+	clusterWatcher = watcher
+	return nil
+}
+
+// StopClusterWatcher stops the shared watcher
+func StopClusterWatcher() {
+	clusterWatcherLock.Lock()
+	defer clusterWatcherLock.Unlock()
+	if clusterWatcher != nil {
+		clusterWatcher.stop()
+		clusterWatcher = nil
+	}
+}
+
+// acquireWatcher returns the shared watcher when it watches the given clients, otherwise a temporary one released by the returned function
+func acquireWatcher(ctx context.Context, machineClient *machinev1beta1.MachineV1beta1Client, clientSet kubernetes.Interface) (*ClusterWatcher, func(), error) {
+	clusterWatcherLock.Lock()
+	watcher := clusterWatcher
+	clusterWatcherLock.Unlock()
+	if watcher != nil && (machineClient == nil || watcher.machineClient == machineClient) && (clientSet == nil || watcher.clientSet == clientSet) {
+		return watcher, func() {}, nil
+	}
+	watcher, err := newClusterWatcher(ctx, machineClient, clientSet)
+	if err != nil {
+		return nil, nil, err
+	}
+	return watcher, watcher.stop, nil
+}
+
+// newClusterWatcher starts the informers of the given clients and waits for their caches to sync
+func newClusterWatcher(ctx context.Context, machineClient *machinev1beta1.MachineV1beta1Client, clientSet kubernetes.Interface) (*ClusterWatcher, error) {
+	watcherCtx, cancel := context.WithCancel(context.Background())
+	watcher := &ClusterWatcher{
+		machineClient: machineClient,
+		clientSet:     clientSet,
+		changed:       make(chan struct{}),
+		machineEvents: make(map[string]map[string]time.Time),
+		nodeEvents:    make(map[string]map[string]time.Time),
+		csrs:          make(map[string]csrStatus),
+		initialNodes:  make(map[string]bool),
+		cancel:        cancel,
+	}
+	hasSynced := make(map[string]cache.InformerSynced)
+	var informerNames []string
+	if machineClient != nil {
+		watcher.machineInformer = cache.NewSharedIndexInformer(&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return machineClient.Machines(MachineNamespace).List(watcherCtx, options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return machineClient.Machines(MachineNamespace).Watch(watcherCtx, options)
+			},
+		}, &machinev1.Machine{}, 0, cache.Indexers{})
+		watcher.machineSetInformer = cache.NewSharedIndexInformer(&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return machineClient.MachineSets(MachineNamespace).List(watcherCtx, options)
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return machineClient.MachineSets(MachineNamespace).Watch(watcherCtx, options)
+			},
+		}, &machinev1.MachineSet{}, 0, cache.Indexers{})
+		if _, err := watcher.machineInformer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+			AddFunc: func(obj interface{}, isInInitialList bool) {
+				watcher.observeMachine(obj, isInInitialList, false)
+			},
+			UpdateFunc: func(_, obj interface{}) {
+				watcher.observeMachine(obj, false, false)
+			},
+			DeleteFunc: func(obj interface{}) {
+				watcher.observeMachine(obj, false, true)
+			},
+		}); err != nil {
+			cancel()
+			return nil, fmt.Errorf("error adding machine event handler: %v", err)
+		}
+		if _, err := watcher.machineSetInformer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc:    func(_ interface{}) { watcher.notify() },
+			UpdateFunc: func(_, _ interface{}) { watcher.notify() },
+			DeleteFunc: func(_ interface{}) { watcher.notify() },
+		}); err != nil {
+			cancel()
+			return nil, fmt.Errorf("error adding machineset event handler: %v", err)
+		}
+		go watcher.machineInformer.Run(watcherCtx.Done())
+		go watcher.machineSetInformer.Run(watcherCtx.Done())
+		hasSynced["machine"] = watcher.machineInformer.HasSynced
+		hasSynced["machineset"] = watcher.machineSetInformer.HasSynced
+		informerNames = append(informerNames, "machine", "machineset")
+	}
+	if clientSet != nil {
+		informerFactory := informers.NewSharedInformerFactory(clientSet, 0)
+		watcher.nodeInformer = informerFactory.Core().V1().Nodes().Informer()
+		watcher.csrInformer = informerFactory.Certificates().V1().CertificateSigningRequests().Informer()
+		if _, err := watcher.nodeInformer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+			AddFunc: func(obj interface{}, isInInitialList bool) {
+				watcher.observeNode(obj, isInInitialList, false)
+			},
+			UpdateFunc: func(_, obj interface{}) {
+				watcher.observeNode(obj, false, false)
+			},
+			DeleteFunc: func(obj interface{}) {
+				watcher.observeNode(obj, false, true)
+			},
+		}); err != nil {
+			cancel()
+			return nil, fmt.Errorf("error adding node event handler: %v", err)
+		}
+		if _, err := watcher.csrInformer.AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+			AddFunc: func(obj interface{}, isInInitialList bool) {
+				watcher.observeCSR(obj, isInInitialList)
+			},
+			UpdateFunc: func(_, obj interface{}) {
+				watcher.observeCSR(obj, false)
+			},
+		}); err != nil {
+			cancel()
+			return nil, fmt.Errorf("error adding CSR event handler: %v", err)
+		}
+		go watcher.nodeInformer.Run(watcherCtx.Done())
+		go watcher.csrInformer.Run(watcherCtx.Done())
+		hasSynced["node"] = watcher.nodeInformer.HasSynced
+		hasSynced["CSR"] = watcher.csrInformer.HasSynced
+		informerNames = append(informerNames, "node", "CSR")
+	}
+	// The run context has no deadline by default, an unreachable API must not hang the run
+	syncCtx, cancelSync := context.WithTimeout(ctx, cacheSyncTimeout)
+	defer cancelSync()
+	for _, informerName := range informerNames {
+		if !cache.WaitForCacheSync(syncCtx.Done(), hasSynced[informerName]) {
+			cancel()
+			return nil, fmt.Errorf("error syncing the %s informer cache: %v", informerName, syncCtx.Err())
+		}
+	}
+	log.Debug("Cluster watcher caches synced")
+	return watcher, nil
+}
+
+// stop stops the informers of the watcher, keeping track of the CSRs left unapproved
+func (w *ClusterWatcher) stop() {
+	w.cancel()
+	recordCSRIssues(w.CSRIssues())
+}
+
+// notify wakes up the waits on the watcher
+func (w *ClusterWatcher) notify() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	close(w.changed)
+	w.changed = make(chan struct{})
+}
+
+// recordEvent records the first time an object reaches a step, must be called with the watcher lock held
+func recordEvent(events map[string]map[string]time.Time, name string, event string, timestamp time.Time) {
+	if _, exists := events[name]; !exists {
+		events[name] = make(map[string]time.Time)
+	}
+	if _, exists := events[name][event]; !exists {
+		events[name][event] = timestamp
+	}
+}
+
+// observedTimestamp returns when an object reached a step as recorded on the object, falling back to when it was observed.
+// Steps reached before the watcher started without a timestamp on the object are recorded with a zero timestamp
+func observedTimestamp(timestamp *metav1.Time, isInInitialList bool) time.Time {
+	if timestamp != nil && !timestamp.IsZero() {
+		return timestamp.Time.UTC()
+	}
+	if isInInitialList {
+		return time.Time{}
+	}
+	return time.Now().UTC()
+}
+
+// observeMachine records the lifecycle steps of a machine
+func (w *ClusterWatcher) observeMachine(obj interface{}, isInInitialList bool, deleted bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	machine, ok := obj.(*machinev1.Machine)
+	if !ok {
+		return
+	}
+	w.mu.Lock()
+	// Only machines created since the watcher started have a creation step
+	if !isInInitialList {
+		recordEvent(w.machineEvents, machine.Name, machineCreatedEvent, observedTimestamp(&machine.CreationTimestamp, false))
+	}
+	if machine.Status.Phase != nil {
+		// The machine controller updates the status timestamp on every phase change
+		recordEvent(w.machineEvents, machine.Name, *machine.Status.Phase, observedTimestamp(machine.Status.LastUpdated, isInInitialList))
+	}
+	if machine.Spec.ProviderID != nil && *machine.Spec.ProviderID != "" {
+		recordEvent(w.machineEvents, machine.Name, machineProviderIDEvent, observedTimestamp(nil, isInInitialList))
+	}
+	if machine.DeletionTimestamp != nil {
+		recordEvent(w.machineEvents, machine.Name, machineDeletingEvent, observedTimestamp(machine.DeletionTimestamp, isInInitialList))
+	}
+	for _, condition := range machine.Status.Conditions {
+		if condition.Type == machineDrainedCondition && condition.Status == corev1.ConditionTrue {
+			recordEvent(w.machineEvents, machine.Name, machineDrainedEvent, observedTimestamp(&condition.LastTransitionTime, isInInitialList))
+		}
+	}
+	if deleted {
+		recordEvent(w.machineEvents, machine.Name, machineDeletedEvent, observedTimestamp(nil, false))
+	}
+	w.mu.Unlock()
+	w.notify()
+}
+
+// observeNode records the lifecycle steps of a node
+func (w *ClusterWatcher) observeNode(obj interface{}, isInInitialList bool, deleted bool) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+	node, ok := obj.(*corev1.Node)
+	if !ok {
+		return
+	}
+	w.mu.Lock()
+	// Only nodes created since the watcher started have a creation step
+	if isInInitialList {
+		w.initialNodes[node.Name] = true
+	} else {
+		recordEvent(w.nodeEvents, node.Name, nodeCreatedEvent, observedTimestamp(&node.CreationTimestamp, false))
+	}
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady && condition.Status == corev1.ConditionTrue {
+			recordEvent(w.nodeEvents, node.Name, nodeReadyEvent, observedTimestamp(&condition.LastTransitionTime, isInInitialList))
+		}
+		if condition.Type == corev1.NodeNetworkUnavailable && condition.Status == corev1.ConditionFalse {
+			recordEvent(w.nodeEvents, node.Name, nodeNetworkReadyEvent, observedTimestamp(&condition.LastTransitionTime, isInInitialList))
+		}
+	}
+	if node.Annotations[machineConfigStateAnnotation] == machineConfigDoneState {
+		recordEvent(w.nodeEvents, node.Name, nodeMachineConfigDoneEvent, observedTimestamp(nil, isInInitialList))
+	}
+	if node.Spec.Unschedulable {
+		recordEvent(w.nodeEvents, node.Name, nodeCordonedEvent, observedTimestamp(nil, isInInitialList))
+	}
+	if deleted {
+		recordEvent(w.nodeEvents, node.Name, nodeDeletedEvent, observedTimestamp(nil, false))
+	}
+	w.mu.Unlock()
+	w.notify()
+}
+
+// MachineEvents returns when a machine reached each step observed by the watcher
+func (w *ClusterWatcher) MachineEvents(name string) map[string]time.Time {
+	return w.events(w.machineEvents, name)
+}
+
+// NodeEvents returns when a node reached each step observed by the watcher
+func (w *ClusterWatcher) NodeEvents(name string) map[string]time.Time {
+	return w.events(w.nodeEvents, name)
+}
+
+// events copies the steps reached by an object
+func (w *ClusterWatcher) events(events map[string]map[string]time.Time, name string) map[string]time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	objectEvents := make(map[string]time.Time)
+	for event, timestamp := range events[name] {
+		objectEvents[event] = timestamp
+	}
+	return objectEvents
+}
+
+// failedMachine returns a machine of the machineset created since the watcher started which reached the Failed phase
+func (w *ClusterWatcher) failedMachine(machineSet string) string {
+	for _, machine := range w.Machines() {
+		if machine.Labels["machine.openshift.io/cluster-api-machineset"] != machineSet {
+			continue
+		}
+		machineEvents := w.MachineEvents(machine.Name)
+		_, created := machineEvents[machineCreatedEvent]
+		if _, failed := machineEvents[machineFailedPhase]; created && failed {
+			return machine.Name
+		}
+	}
+	return ""
+}
+
+// scaledNodes returns the names of the nodes backing the machines created since the watcher started, nil when machines are not watched
+func (w *ClusterWatcher) scaledNodes() map[string]bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.scaledMachineNodes()
+}
+
+// scaledMachineNodes returns the names of the nodes backing the machines created since the watcher started, nil when machines
+// are not watched. Nodes which did not join yet are known by the addresses of their machine. Must be called with the watcher lock held
+func (w *ClusterWatcher) scaledMachineNodes() map[string]bool {
+	if w.machineInformer == nil {
+		return nil
+	}
+	scaledNodes := make(map[string]bool)
+	for _, machine := range w.Machines() {
+		if _, created := w.machineEvents[machine.Name][machineCreatedEvent]; !created {
+			continue
+		}
+		if machine.Status.NodeRef != nil {
+			scaledNodes[machine.Status.NodeRef.Name] = true
+		}
+		for _, address := range machine.Status.Addresses {
+			if address.Type == corev1.NodeInternalDNS || address.Type == corev1.NodeHostName {
+				scaledNodes[address.Address] = true
+			}
+		}
+	}
+	return scaledNodes
+}
+
+// Machines returns the machines in the watcher cache
+func (w *ClusterWatcher) Machines() []*machinev1.Machine {
+	var machines []*machinev1.Machine
+	for _, obj := range w.machineInformer.GetStore().List() {
+		machines = append(machines, obj.(*machinev1.Machine))
+	}
+	return machines
+}
+
+// MachineSets returns the machinesets in the watcher cache
+func (w *ClusterWatcher) MachineSets() []*machinev1.MachineSet {
+	var machineSets []*machinev1.MachineSet
+	for _, obj := range w.machineSetInformer.GetStore().List() {
+		machineSets = append(machineSets, obj.(*machinev1.MachineSet))
+	}
+	return machineSets
+}
+
+// Nodes returns the nodes in the watcher cache
+func (w *ClusterWatcher) Nodes() []*corev1.Node {
+	var nodes []*corev1.Node
+	for _, obj := range w.nodeInformer.GetStore().List() {
+		nodes = append(nodes, obj.(*corev1.Node))
+	}
+	return nodes
+}
+
+// WaitFor waits until the condition is met or the context is done, evaluating it every time the watched objects change
+func (w *ClusterWatcher) WaitFor(ctx context.Context, condition func() bool) error {
+	for {
+		w.mu.Lock()
+		changed := w.changed
+		w.mu.Unlock()
+		if condition() {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// waitForObjects waits until the condition holds for the objects of a list and watch or the context is done, evaluating it every time one of them changes
+func waitForObjects(ctx context.Context, listWatch cache.ListerWatcher, objType runtime.Object, condition func(objs []interface{}) bool) error {
+	var store cache.Store
+	_, err := watchtools.UntilWithSync(ctx, listWatch, objType, func(synced cache.Store) (bool, error) {
+		store = synced
+		return condition(store.List()), nil
+	}, func(_ watch.Event) (bool, error) {
+		return condition(store.List()), nil
+	})
+	return err
+}
+
+// capiListWatch lists and watches cluster api objects matching the list options
+func capiListWatch(ctx context.Context, capiClient client.WithWatch, list client.ObjectList, listOptions *client.ListOptions) cache.ListerWatcher {
+	return &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			objectList := list.DeepCopyObject().(client.ObjectList)
+			err := capiClient.List(ctx, objectList, &client.ListOptions{
+				Namespace:     listOptions.Namespace,
+				LabelSelector: listOptions.LabelSelector,
+				FieldSelector: listOptions.FieldSelector,
+				Raw:           &options,
+			})
+			return objectList, err
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			return capiClient.Watch(ctx, list.DeepCopyObject().(client.ObjectList), &client.ListOptions{
+				Namespace:     listOptions.Namespace,
+				LabelSelector: listOptions.LabelSelector,
+				FieldSelector: listOptions.FieldSelector,
+				Raw:           &options,
+			})
+		},
+	}
+}